@@ -0,0 +1,505 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/handlers"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/notify"
+	"local/gin/gin-recipes-api/oidc"
+	"local/gin/gin-recipes-api/store"
+	"log"
+	"net/http"
+	"time"
+
+	ginopentracing "github.com/Bose/go-gin-opentracing"
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-contrib/sessions"
+	redisStore "github.com/gin-contrib/sessions/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	ginprometheus "github.com/zsais/go-gin-prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// App wires the configuration, backends, handlers and router of the recipes API.
+type App struct {
+	cfg *config.Config
+
+	recipeStore  store.RecipeStore
+	userStore    store.UserStore
+	apiKeyStore  store.APIKeyStore
+	redisClient  *redis.Client
+	sessionStore sessions.Store
+	oidcProvider oidc.Provider
+	tracer       opentracing.Tracer
+
+	// Resources created by New, released in this order by Shutdown.
+	mongoClient  *mongo.Client
+	db           *sql.DB
+	ownsSessions bool
+	ownsRedis    bool
+	tracerCloser io.Closer
+
+	recipesHandler  *handlers.RecipesHandler
+	authHandler     *handlers.AuthHandler
+	passwordHandler *handlers.PasswordHandler
+	router          *gin.Engine
+}
+
+// Option overrides a dependency that New would otherwise construct from the config.
+type Option func(*App)
+
+// WithRecipeStore makes the App use s instead of the configured store backend.
+func WithRecipeStore(s store.RecipeStore) Option {
+	return func(a *App) {
+		a.recipeStore = s
+	}
+}
+
+// WithUserStore makes the App use s instead of the configured store backend.
+func WithUserStore(s store.UserStore) Option {
+	return func(a *App) {
+		a.userStore = s
+	}
+}
+
+// WithAPIKeyStore makes the App use s instead of the configured store backend.
+func WithAPIKeyStore(s store.APIKeyStore) Option {
+	return func(a *App) {
+		a.apiKeyStore = s
+	}
+}
+
+// WithRedisClient makes the App use c instead of connecting to the configured Redis.
+func WithRedisClient(c *redis.Client) Option {
+	return func(a *App) {
+		a.redisClient = c
+	}
+}
+
+// WithSessionStore makes the App use s instead of the Redis backed session
+// store. Like an injected Redis client, s is left open by Shutdown.
+func WithSessionStore(s sessions.Store) Option {
+	return func(a *App) {
+		a.sessionStore = s
+	}
+}
+
+// WithOIDCProvider makes the App use p instead of discovering the configured
+// OpenID Connect provider. It enables OIDC sign-in regardless of the config.
+func WithOIDCProvider(p oidc.Provider) Option {
+	return func(a *App) {
+		a.oidcProvider = p
+	}
+}
+
+// WithTracer makes the App use t instead of reporting to the configured Jaeger agent.
+func WithTracer(t opentracing.Tracer) Option {
+	return func(a *App) {
+		a.tracer = t
+	}
+}
+
+// New constructs all dependencies of the API and registers its routes.
+// Resources acquired before an error occurs are released again.
+func New(ctx context.Context, cfg *config.Config, opts ...Option) (*App, error) {
+	a := &App{
+		cfg: cfg,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if err := a.init(ctx); err != nil {
+		a.Shutdown(ctx)
+		return nil, err
+	}
+	return a, nil
+}
+
+// Router returns the gin.Engine serving the API, e.g. for use with httptest.
+func (a *App) Router() *gin.Engine {
+	return a.router
+}
+
+// Run serves the API on the configured address until ctx is done. It then
+// stops accepting connections and waits up to http.shutdownTimeout for
+// in-flight requests to complete.
+func (a *App) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    a.cfg.HTTP.Addr,
+		Handler: a.router,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", a.cfg.HTTP.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("Shutting down, draining connections for up to %s", a.cfg.HTTP.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		return errors.Wrap(err, "While draining connections")
+	}
+	return nil
+}
+
+// Shutdown releases the resources created by New: the store backends
+// first, then the session store and the Redis client, and finally the
+// tracer, so that spans recorded during shutdown are still flushed.
+func (a *App) Shutdown(ctx context.Context) error {
+	var first error
+	record := func(err error, what string) {
+		if err != nil {
+			log.Printf("While closing %s: %s", what, err.Error())
+			if first == nil {
+				first = errors.Wrapf(err, "While closing %s", what)
+			}
+		}
+	}
+	if a.mongoClient != nil {
+		record(a.mongoClient.Disconnect(ctx), "MongoDB client")
+		a.mongoClient = nil
+	}
+	if a.db != nil {
+		record(a.db.Close(), "SQL database")
+		a.db = nil
+	}
+	if a.ownsSessions {
+		if closer, ok := a.sessionStore.(io.Closer); ok {
+			record(closer.Close(), "session store")
+		}
+		a.ownsSessions = false
+	}
+	if a.ownsRedis {
+		record(a.redisClient.Close(), "Redis client")
+		a.ownsRedis = false
+	}
+	if a.tracerCloser != nil {
+		record(a.tracerCloser.Close(), "tracer")
+		a.tracerCloser = nil
+	}
+	return first
+}
+
+func (a *App) init(ctx context.Context) error {
+	if a.redisClient == nil {
+		a.redisClient = redis.NewClient(&redis.Options{
+			Addr:     a.cfg.Redis.Addr,
+			Password: a.cfg.Redis.Password,
+			DB:       a.cfg.Redis.DB,
+		})
+		a.ownsRedis = true
+		if err := a.redisClient.Ping().Err(); err != nil {
+			return errors.Wrap(err, "While connecting to Redis")
+		}
+		log.Println("Connected to Redis")
+	}
+	if err := a.initStores(ctx); err != nil {
+		return err
+	}
+	if err := a.promoteAdmin(ctx); err != nil {
+		return err
+	}
+	if a.sessionStore == nil {
+		s, err := redisStore.NewStore(10, "tcp", a.cfg.Redis.Addr, a.cfg.Redis.Password, []byte(a.cfg.Session.Secret))
+		if err != nil {
+			return errors.Wrap(err, "While creating session store")
+		}
+		a.sessionStore = s
+		a.ownsSessions = true
+	}
+	a.sessionStore.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   int(a.cfg.Session.MaxAge.Seconds()),
+		HttpOnly: true,
+	})
+	if a.tracer == nil {
+		// the tracer closer also closes and thereby flushes the reporter
+		tracer, _, closer, err := ginopentracing.InitTracing(a.cfg.Tracing.ServiceName, a.cfg.Tracing.AgentAddr, ginopentracing.WithEnableInfoLog(true))
+		if err != nil {
+			return errors.Wrap(err, "While initializing tracing")
+		}
+		a.tracerCloser = closer
+		a.tracer = tracer
+	}
+	opentracing.SetGlobalTracer(a.tracer)
+	if a.oidcProvider == nil && a.cfg.OIDC.Enabled() {
+		provider, err := oidc.Discover(ctx, oidc.Config{
+			Issuer:       a.cfg.OIDC.Issuer,
+			ClientID:     a.cfg.OIDC.ClientID,
+			ClientSecret: a.cfg.OIDC.ClientSecret,
+			RedirectURL:  a.cfg.OIDC.RedirectURL,
+		}, nil)
+		if err != nil {
+			return errors.Wrap(err, "While discovering OpenID Connect provider")
+		}
+		log.Printf("Using OpenID Connect provider %s", a.cfg.OIDC.Issuer)
+		a.oidcProvider = provider
+	}
+
+	refreshTokens := store.NewRefreshTokenStore(a.redisClient, a.cfg.Auth.RefreshTokenTTL)
+	throttle := a.signInThrottle()
+	sessionRegistry := store.NewSessionRegistry(a.redisClient, a.cfg.Session.MaxAge)
+	a.recipesHandler = handlers.NewRecipesHandler(ctx, a.recipeStore, a.redisClient)
+	a.authHandler = handlers.NewAuthHandler(ctx, a.userStore, a.apiKeyStore, refreshTokens, throttle, store.NewChallengeStore(a.redisClient, 5*time.Minute, 5), sessionRegistry, a.oidcProvider, a.cfg.Auth)
+	a.passwordHandler = handlers.NewPasswordHandler(ctx, a.userStore, refreshTokens, sessionRegistry,
+		store.NewPasswordResetStore(a.redisClient, a.cfg.Auth.PasswordResetTTL, time.Minute, 10), throttle, a.notifier(), a.cfg.Notify.ResetURL)
+	a.router = gin.Default()
+	if err := a.router.SetTrustedProxies(a.cfg.HTTP.TrustedProxies); err != nil {
+		return errors.Wrap(err, "While setting trusted proxies")
+	}
+	a.routes()
+	return nil
+}
+
+func (a *App) notifier() notify.Notifier {
+	switch a.cfg.Notify.Backend {
+	case "file":
+		return notify.NewFileNotifier(a.cfg.Notify.File)
+	case "smtp":
+		smtp := a.cfg.Notify.SMTP
+		return notify.NewSMTPNotifier(smtp.Addr, smtp.Username, smtp.Password, a.cfg.Notify.From)
+	default:
+		return notify.LogNotifier{}
+	}
+}
+
+func (a *App) signInThrottle() *store.SignInThrottle {
+	l := a.cfg.Lockout
+	return store.NewSignInThrottle(a.redisClient, l.UserThreshold, l.IPThreshold, l.Window, l.Backoff, l.Duration)
+}
+
+// initStores opens the configured store backend for all stores not injected via options.
+func (a *App) initStores(ctx context.Context) error {
+	if a.recipeStore != nil && a.userStore != nil && a.apiKeyStore != nil {
+		return nil
+	}
+	var recipeStore store.RecipeStore
+	var userStore store.UserStore
+	var apiKeyStore store.APIKeyStore
+	switch a.cfg.Store.Backend {
+	case "memory":
+		recipes, err := store.LoadRecipes(a.cfg.Store.Seed)
+		if err != nil {
+			return err
+		}
+		recipeStore = store.NewMemoryStore(recipes)
+		userStore = store.NewMemoryUserStore()
+		apiKeyStore = store.NewMemoryAPIKeyStore()
+		log.Printf("%d items in memory store", len(recipes))
+	case "sql":
+		sqlStore, err := a.openSQLStore(ctx)
+		if err != nil {
+			return err
+		}
+		recipeStore = sqlStore
+		userStore = sqlStore
+		apiKeyStore = sqlStore
+	case "mongo":
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(a.cfg.Mongo.URI))
+		if err != nil {
+			return errors.Wrap(err, "While connecting to MongoDB")
+		}
+		a.mongoClient = client
+		if err := client.Ping(ctx, readpref.Primary()); err != nil {
+			return errors.Wrap(err, "While connecting to MongoDB")
+		}
+		log.Println("Connected to MongoDB")
+		collection := client.Database(a.cfg.Mongo.Database).Collection("recipes")
+		if err := seedCollection(ctx, collection, a.cfg.Store.Seed); err != nil {
+			return err
+		}
+		mongoRecipes := store.NewMongoStore(collection)
+		if err := mongoRecipes.EnsureIndexes(ctx); err != nil {
+			return errors.Wrap(err, "While creating index on recipes")
+		}
+		parsed, err := mongoRecipes.BackfillIngredients(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Parsed ingredients of %d recipes", parsed)
+		recipeStore = mongoRecipes
+		mongoUsers := store.NewMongoUserStore(client.Database(a.cfg.Mongo.Database).Collection("users"))
+		if err := mongoUsers.EnsureIndexes(ctx); err != nil {
+			return errors.Wrap(err, "While creating index on users")
+		}
+		backfilled, err := mongoUsers.BackfillRoles(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Assigned roles to %d users", backfilled)
+		userStore = mongoUsers
+		mongoAPIKeys := store.NewMongoAPIKeyStore(client.Database(a.cfg.Mongo.Database).Collection("apikeys"))
+		if err := mongoAPIKeys.EnsureIndexes(ctx); err != nil {
+			return errors.Wrap(err, "While creating index on apikeys")
+		}
+		apiKeyStore = mongoAPIKeys
+	default:
+		return errors.Errorf("unknown store backend '%s'", a.cfg.Store.Backend)
+	}
+	if a.recipeStore == nil {
+		a.recipeStore = recipeStore
+	}
+	if a.userStore == nil {
+		a.userStore = userStore
+	}
+	if a.apiKeyStore == nil {
+		a.apiKeyStore = apiKeyStore
+	}
+	return nil
+}
+
+// promoteAdmin makes the user named by auth.admin an admin, so that a fresh
+// deployment has someone to assign roles.
+func (a *App) promoteAdmin(ctx context.Context) error {
+	username := a.cfg.Auth.Admin
+	if username == "" {
+		return nil
+	}
+	err := a.userStore.UpdateRole(ctx, username, models.RoleAdmin)
+	if err == store.ErrUserNotFound {
+		log.Printf("auth.admin: user %s does not exist yet, sign up and restart to promote it", username)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "While promoting %s to admin", username)
+	}
+	log.Printf("Promoted %s to admin", username)
+	return nil
+}
+
+// seedCollection inserts the recipes from the seed file if the collection is empty.
+func seedCollection(ctx context.Context, collection *mongo.Collection, seed string) error {
+	itemCount, err := collection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	log.Printf("%d items in collection 'recipes", itemCount)
+	if itemCount != 0 || seed == "" {
+		return nil
+	}
+	recipes, err := store.LoadRecipes(seed)
+	if err != nil {
+		return err
+	}
+	var listOfRecipes []interface{}
+	for _, recipe := range recipes {
+		listOfRecipes = append(listOfRecipes, recipe)
+	}
+	insertManyResult, err := collection.InsertMany(ctx, listOfRecipes)
+	if err != nil {
+		return err
+	}
+	log.Println("Insert recipes: ", len(insertManyResult.InsertedIDs))
+	return nil
+}
+
+// openSQLStore connects to the configured SQL database, migrates the schema
+// and seeds an empty database.
+func (a *App) openSQLStore(ctx context.Context) (*store.SQLStore, error) {
+	driver := a.cfg.SQL.Driver
+	db, err := sql.Open(driver, a.cfg.SQL.DSN)
+	if err != nil {
+		return nil, err
+	}
+	a.db = db
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Connected to %s", driver)
+	sqlStore, err := store.NewSQLStore(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := sqlStore.Migrate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Applied %d migrations", applied)
+	parsed, err := sqlStore.BackfillIngredients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Parsed %d ingredients", parsed)
+	itemCount, err := sqlStore.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%d items in table 'recipes'", itemCount)
+	if itemCount == 0 && a.cfg.Store.Seed != "" {
+		recipes, err := store.LoadRecipes(a.cfg.Store.Seed)
+		if err != nil {
+			return nil, err
+		}
+		if err := sqlStore.Insert(ctx, recipes); err != nil {
+			return nil, err
+		}
+		log.Println("Insert recipes: ", len(recipes))
+	}
+	return sqlStore, nil
+}
+
+func (a *App) routes() {
+	router := a.router
+	tracer := a.tracer
+	// RedisStore for user sessions
+	router.Use(sessions.Sessions(a.cfg.Session.Name, a.sessionStore))
+	p := ginprometheus.NewPrometheus("gin")
+	p.Use(router)
+	router.GET("/recipes", opengintracing.NewSpan(tracer, "GET:/recipes"), a.recipesHandler.ListRecipesHandler)
+	router.GET("/recipes/search", opengintracing.NewSpan(tracer, "GET:/recipes/search"), a.recipesHandler.SearchRecipeHandler)
+	router.GET("/recipes/:id", opengintracing.NewSpan(tracer, "GET:/recipes/:id"), a.recipesHandler.GetRecipeHandler)
+	router.POST("/recipes/cook", opengintracing.NewSpan(tracer, "POST:/recipes/cook"), a.recipesHandler.CookHandler)
+	router.GET("/users/:username/recipes", opengintracing.NewSpan(tracer, "GET:/users/:username/recipes"), a.recipesHandler.ListUserRecipesHandler)
+	router.POST("/signup", opengintracing.NewSpan(tracer, "POST:/signup"), a.authHandler.SignUpHandler)
+	router.POST("/signin", opengintracing.NewSpan(tracer, "POST:/signin"), a.authHandler.SignInHandler)
+	router.POST("/signout", opengintracing.NewSpan(tracer, "POST:/signout"), a.authHandler.SignOutHandler)
+	router.POST("/refresh", opengintracing.NewSpan(tracer, "POST:/refresh"), a.authHandler.RefreshHandler)
+	router.POST("/password/forgot", opengintracing.NewSpan(tracer, "POST:/password/forgot"), a.passwordHandler.ForgotPasswordHandler)
+	router.POST("/password/reset", opengintracing.NewSpan(tracer, "POST:/password/reset"), a.passwordHandler.ResetPasswordHandler)
+	router.POST("/signin/totp", opengintracing.NewSpan(tracer, "POST:/signin/totp"), a.authHandler.TOTPSignInHandler)
+	if a.oidcProvider != nil {
+		router.GET("/oidc/login", opengintracing.NewSpan(tracer, "GET:/oidc/login"), a.authHandler.OIDCLoginHandler)
+		router.GET("/oidc/callback", opengintracing.NewSpan(tracer, "GET:/oidc/callback"), a.authHandler.OIDCCallbackHandler)
+	}
+
+	authorized := router.Group("/")
+	authorized.Use(a.authHandler.AuthMiddleware())
+	// create the middleware
+	authorized.POST("/recipes", opengintracing.NewSpan(tracer, "POST:/recipes"),
+		a.authHandler.RequirePermission(models.PermCreateRecipe), a.recipesHandler.NewRecipeHandler)
+	authorized.PUT("/recipes/:id", opengintracing.NewSpan(tracer, "PUT:/recipes/:id"),
+		a.authHandler.RequirePermission(models.PermUpdateRecipe), a.recipesHandler.UpdateRecipeHandler)
+	authorized.DELETE("/recipes/:id", opengintracing.NewSpan(tracer, "DELETE:/recipes/:id"),
+		a.authHandler.RequirePermission(models.PermDeleteRecipe), a.recipesHandler.DeleteRecipeHandler)
+	authorized.PUT("/users/:username/role", opengintracing.NewSpan(tracer, "PUT:/users/:username/role"),
+		a.authHandler.RequirePermission(models.PermManageUsers), a.authHandler.UpdateRoleHandler)
+	authorized.GET("/users/:username/sessions", opengintracing.NewSpan(tracer, "GET:/users/:username/sessions"),
+		a.authHandler.RequirePermission(models.PermManageUsers), a.authHandler.ListUserSessionsHandler)
+	authorized.DELETE("/users/:username/sessions", opengintracing.NewSpan(tracer, "DELETE:/users/:username/sessions"),
+		a.authHandler.RequirePermission(models.PermManageUsers), a.authHandler.RevokeUserSessionsHandler)
+	authorized.GET("/users/me/sessions", opengintracing.NewSpan(tracer, "GET:/users/me/sessions"), a.authHandler.ListSessionsHandler)
+	authorized.DELETE("/users/me/sessions/:id", opengintracing.NewSpan(tracer, "DELETE:/users/me/sessions/:id"), a.authHandler.DeleteSessionHandler)
+	authorized.PUT("/users/me/password", opengintracing.NewSpan(tracer, "PUT:/users/me/password"), a.passwordHandler.ChangePasswordHandler)
+	authorized.POST("/users/me/apikeys", opengintracing.NewSpan(tracer, "POST:/users/me/apikeys"), a.authHandler.NewAPIKeyHandler)
+	authorized.GET("/users/me/apikeys", opengintracing.NewSpan(tracer, "GET:/users/me/apikeys"), a.authHandler.ListAPIKeysHandler)
+	authorized.DELETE("/users/me/apikeys/:id", opengintracing.NewSpan(tracer, "DELETE:/users/me/apikeys/:id"), a.authHandler.DeleteAPIKeyHandler)
+	authorized.POST("/users/me/totp", opengintracing.NewSpan(tracer, "POST:/users/me/totp"), a.authHandler.EnrollTOTPHandler)
+	authorized.POST("/users/me/totp/confirm", opengintracing.NewSpan(tracer, "POST:/users/me/totp/confirm"), a.authHandler.ConfirmTOTPHandler)
+	authorized.DELETE("/users/me/totp", opengintracing.NewSpan(tracer, "DELETE:/users/me/totp"), a.authHandler.DisableTOTPHandler)
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateNamesSettings(t *testing.T) {
+	cfg := Default()
+	cfg.Session.Secret = ""
+	cfg.Mongo.URI = ""
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() accepted a configuration without session.secret")
+	}
+	for _, want := range []string{
+		"session.secret is required",
+		"set SESSION_SECRET or -session.secret",
+		"mongo.uri is required, set MONGO_URI or -mongo.uri",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %q, want it to contain %q", err, want)
+		}
+	}
+
+	cfg.Session.Secret = "a long random string"
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want the defaults with a secret accepted", err)
+	}
+}
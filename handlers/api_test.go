@@ -0,0 +1,237 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"local/gin/gin-recipes-api/app"
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/store"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/opentracing/opentracing-go"
+)
+
+// testPassword is accepted by password.Validate for every test user.
+const testPassword = "Correct horse battery staple 1"
+
+// testAPI serves the recipes API through httptest, backed by memory stores,
+// or the stores of the configured backend, and a miniredis server.
+type testAPI struct {
+	*httptest.Server
+	cfg     *config.Config
+	users   store.UserStore
+	recipes store.RecipeStore
+	redis   *miniredis.Miniredis
+}
+
+// newTestAPI starts the API. configure may change the configuration, in
+// which the server URL is already known, before the App is built.
+func newTestAPI(t *testing.T, configure func(cfg *config.Config, url string), opts ...app.Option) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	var router http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default()
+	cfg.Store.Backend = "memory"
+	cfg.Redis.Addr = mr.Addr()
+	cfg.Session.Secret = "test-secret"
+	cfg.Auth.JWTSecret = "test-jwt-secret"
+	if configure != nil {
+		configure(cfg, srv.URL)
+	}
+	api := &testAPI{
+		Server: srv,
+		cfg:    cfg,
+		redis:  mr,
+	}
+	var defaults []app.Option
+	if cfg.Store.Backend == "memory" {
+		users := store.NewMemoryUserStore()
+		recipes := store.NewMemoryStore(nil)
+		api.users, api.recipes = users, recipes
+		defaults = append(defaults,
+			app.WithRecipeStore(recipes),
+			app.WithUserStore(users),
+			app.WithAPIKeyStore(store.NewMemoryAPIKeyStore()))
+	}
+	opts = append(append(defaults,
+		app.WithRedisClient(redisClient),
+		app.WithSessionStore(cookie.NewStore([]byte(cfg.Session.Secret))),
+		app.WithTracer(opentracing.NoopTracer{})), opts...)
+	a, err := app.New(context.Background(), cfg, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Shutdown(context.Background()) })
+	router = a.Router()
+	if cfg.Store.Backend == "sql" {
+		// a second connection to the database the App migrated
+		db, err := sql.Open(cfg.SQL.Driver, cfg.SQL.DSN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		sqlStore, err := store.NewSQLStore(db, cfg.SQL.Driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		api.users, api.recipes = sqlStore, sqlStore
+	}
+	return api
+}
+
+// sqlBackend configures the SQL store on an SQLite database in dir.
+func sqlBackend(dir string) func(cfg *config.Config, url string) {
+	return func(cfg *config.Config, url string) {
+		cfg.Store.Backend = "sql"
+		cfg.Store.Seed = ""
+		cfg.SQL.Driver = "sqlite3"
+		cfg.SQL.DSN = filepath.Join(dir, "recipes.db") + "?_busy_timeout=5000"
+	}
+}
+
+// client is an API client keeping the cookies of its session. It does not
+// follow redirects.
+type client struct {
+	t      *testing.T
+	api    *testAPI
+	http   *http.Client
+	header http.Header
+}
+
+func (api *testAPI) client(t *testing.T) *client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{
+		t:   t,
+		api: api,
+		http: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		header: make(http.Header),
+	}
+}
+
+// response is a response of the API with its body read.
+type response struct {
+	*http.Response
+	body []byte
+}
+
+// decode unmarshals the JSON body into v.
+func (r response) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("While decoding %s: %v", r.body, err)
+	}
+}
+
+// field returns a string field of the JSON object in the body.
+func (r response) field(t *testing.T, name string) string {
+	t.Helper()
+	var fields map[string]interface{}
+	r.decode(t, &fields)
+	value, _ := fields[name].(string)
+	return value
+}
+
+// do sends a request to path, relative to the API unless it is a full URL,
+// with body encoded as JSON.
+func (c *client) do(method, path string, body interface{}) response {
+	c.t.Helper()
+	if !strings.HasPrefix(path, "http") {
+		path = c.api.URL + path
+	}
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return response{res, data}
+}
+
+// expect sends a request and fails the test unless it is answered with status.
+func (c *client) expect(status int, method, path string, body interface{}) response {
+	c.t.Helper()
+	res := c.do(method, path, body)
+	if res.StatusCode != status {
+		c.t.Fatalf("%s %s = %d %s, want %d", method, path, res.StatusCode, res.body, status)
+	}
+	return res
+}
+
+// signUp creates a user with testPassword and the given role.
+func (api *testAPI) signUp(t *testing.T, username string, role models.Role) {
+	t.Helper()
+	api.client(t).expect(http.StatusCreated, http.MethodPost, "/signup", gin.H{
+		"username": username,
+		"password": testPassword,
+		"email":    username + "@example.com",
+	})
+	if err := api.users.UpdateRole(context.Background(), username, role); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signIn returns a client signed in as username.
+func (api *testAPI) signIn(t *testing.T, username string) *client {
+	t.Helper()
+	c := api.client(t)
+	c.expect(http.StatusOK, http.MethodPost, "/signin", gin.H{"username": username, "password": testPassword})
+	return c
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/store"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+const (
+	// apiKeyHeader carries the API key of machine-to-machine clients.
+	apiKeyHeader = "X-API-Key"
+	// apiKeyPrefix makes API keys recognizable, e.g. by secret scanners.
+	apiKeyPrefix = "rcp_"
+	// apiKeyKey is the gin.Context key under which AuthMiddleware stores the
+	// API key a request was authenticated with.
+	apiKeyKey = "apikey"
+)
+
+type APIKeyInput struct {
+	Name   string              `json:"name" binding:"required"`
+	Scopes []models.Permission `json:"scopes" binding:"required"`
+	// ExpiresIn is a duration like "720h" after which the key stops working.
+	ExpiresIn string `json:"expiresIn" binding:"required"`
+}
+
+// APIKeyOutput is returned once when a key is created; the key cannot be
+// retrieved again afterwards.
+type APIKeyOutput struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// NewAPIKeyHandler mints an API key for the current user. The key may only
+// be granted scopes the user's role has.
+func (h *AuthHandler) NewAPIKeyHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "NewAPIKeyHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	var input APIKeyInput
+	sp_json := NewSubSpan(sp, "BindJSON(input)")
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		sp_json.Finish()
+		return
+	}
+	sp_json.Finish()
+	expiresIn, err := time.ParseDuration(input.ExpiresIn)
+	if err != nil || expiresIn <= 0 || expiresIn > h.cfg.APIKeyMaxTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn must be a positive duration of at most " + h.cfg.APIKeyMaxTTL.String()})
+		return
+	}
+	sp_user := NewSubSpan(sp, "Store.GetUser()")
+	user, err := h.users.GetUser(c.Request.Context(), CurrentUser(c))
+	sp_user.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !scope.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + string(scope)})
+			return
+		}
+		if !user.Role.Can(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Role " + string(user.Role) + " cannot grant scope " + string(scope)})
+			return
+		}
+	}
+	key, err := generateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	apiKey := models.APIKey{
+		ID:        xid.New().String(),
+		Username:  user.Username,
+		Name:      input.Name,
+		Hash:      hashAPIKey(key),
+		Scopes:    input.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiresIn),
+	}
+	sp_create := NewSubSpan(sp, "Store.CreateAPIKey()")
+	err = h.apiKeys.CreateAPIKey(c.Request.Context(), &apiKey)
+	sp_create.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, APIKeyOutput{APIKey: apiKey, Key: key})
+}
+
+// ListAPIKeysHandler returns the API keys of the current user without the keys themselves.
+func (h *AuthHandler) ListAPIKeysHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ListAPIKeysHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	sp_list := NewSubSpan(sp, "Store.ListAPIKeys()")
+	keys, err := h.apiKeys.ListAPIKeys(c.Request.Context(), CurrentUser(c))
+	sp_list.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// DeleteAPIKeyHandler revokes an API key of the current user.
+func (h *AuthHandler) DeleteAPIKeyHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "DeleteAPIKeyHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	sp_delete := NewSubSpan(sp, "Store.DeleteAPIKey()")
+	err := h.apiKeys.DeleteAPIKey(c.Request.Context(), CurrentUser(c), c.Param("id"))
+	sp_delete.Finish()
+	if errors.Is(err, store.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key has been deleted"})
+}
+
+// authenticateAPIKey resolves the API key given in the X-API-Key header and
+// records its use. It aborts the request if the key is unknown or expired.
+func (h *AuthHandler) authenticateAPIKey(c *gin.Context, key string) bool {
+	apiKey, err := h.apiKeys.GetAPIKeyByHash(c.Request.Context(), hashAPIKey(key))
+	if errors.Is(err, store.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Abort()
+		return false
+	}
+	now := time.Now()
+	if now.After(apiKey.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key has expired"})
+		c.Abort()
+		return false
+	}
+	if err := h.apiKeys.TouchAPIKey(c.Request.Context(), apiKey.ID, now); err != nil {
+		log.Printf("While recording use of API key %s: %s", apiKey.ID, err.Error())
+	}
+	c.Set(userKey, apiKey.Username)
+	c.Set(apiKeyKey, apiKey)
+	return true
+}
+
+// rejectAPIKey responds with 403 Forbidden to requests authenticated with an
+// API key, for endpoints managing credentials that keys must not escalate to.
+func rejectAPIKey(c *gin.Context) bool {
+	if _, ok := currentAPIKey(c); !ok {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed with an API key"})
+	return true
+}
+
+// currentAPIKey returns the API key the request was authenticated with, if any.
+func currentAPIKey(c *gin.Context) (models.APIKey, bool) {
+	value, _ := c.Get(apiKeyKey)
+	key, ok := value.(models.APIKey)
+	return key, ok
+}
+
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "While generating API key")
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashAPIKey returns the digest under which a key is stored. API keys are
+// random with 256 bits of entropy, so a fast unsalted hash suffices.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
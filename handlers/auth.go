@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"context"
-	"crypto/sha256"
+	"local/gin/gin-recipes-api/config"
 	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/oidc"
+	"local/gin/gin-recipes-api/password"
+	"local/gin/gin-recipes-api/store"
+	"log"
 	"net/http"
-	"os"
+	"regexp"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -13,38 +17,158 @@ import (
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
-	"github.com/rs/xid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/pkg/errors"
 )
 
+// userKey is the gin.Context key under which AuthMiddleware stores the username.
+const userKey = "username"
+
+// sessionIDKey is the gin.Context key under which AuthMiddleware stores the
+// ID of the session a request was authenticated with.
+const sessionIDKey = "session"
+
 type AuthHandler struct {
-	collection *mongo.Collection
-	ctx        context.Context
+	users         store.UserStore
+	apiKeys       store.APIKeyStore
+	refreshTokens *store.RefreshTokenStore
+	throttle      *store.SignInThrottle
+	challenges    *store.ChallengeStore
+	sessions      *store.SessionRegistry
+	provider      oidc.Provider
+	ctx           context.Context
+	cfg           config.AuthConfig
 }
 
-func NewAuthHandler(ctx context.Context, collection *mongo.Collection) *AuthHandler {
+func NewAuthHandler(ctx context.Context, users store.UserStore, apiKeys store.APIKeyStore, refreshTokens *store.RefreshTokenStore, throttle *store.SignInThrottle, challenges *store.ChallengeStore, sessions *store.SessionRegistry, provider oidc.Provider, cfg config.AuthConfig) *AuthHandler {
 	return &AuthHandler{
-		collection: collection,
-		ctx:        ctx,
+		users:         users,
+		apiKeys:       apiKeys,
+		refreshTokens: refreshTokens,
+		throttle:      throttle,
+		challenges:    challenges,
+		sessions:      sessions,
+		provider:      provider,
+		ctx:           ctx,
+		cfg:           cfg,
 	}
 }
 
 type Claims struct {
 	Username string `json:"username"`
+	// Type is always "access"; refresh tokens are opaque.
+	Type string `json:"type"`
+	// Family identifies the sign-in the token belongs to, see store.RefreshTokenStore.
+	Family string `json:"fam"`
 	jwt.StandardClaims
 }
 
 type JWTOutput struct {
-	Token   string    `json:"token"`
-	Expires time.Time `json:"expires"`
+	Token          string    `json:"token"`
+	Expires        time.Time `json:"expires"`
+	RefreshToken   string    `json:"refreshToken"`
+	RefreshExpires time.Time `json:"refreshExpires"`
+}
+
+type SignUpInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
 }
 
+// UserOutput is the representation of a user returned to clients; it never
+// contains the password hash.
+type UserOutput struct {
+	Username  string      `json:"username"`
+	Email     string      `json:"email"`
+	Role      models.Role `json:"role"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+func (h *AuthHandler) SignUpHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "SignUpHandler")
+	defer sp.Finish()
+	var input SignUpInput
+	sp_json := NewSubSpan(sp, "BindJSON(input)")
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		sp_json.Finish()
+		return
+	}
+	if !usernamePattern.MatchString(input.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username must have 3 to 32 characters out of letters, digits, '_', '.' and '-'"})
+		sp_json.Finish()
+		return
+	}
+	if err := password.Validate(input.Password, input.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		sp_json.Finish()
+		return
+	}
+	sp_json.Finish()
+	sp_hash := NewSubSpan(sp, "password.Hash()")
+	hashed, err := password.Hash(input.Password)
+	sp_hash.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user := models.User{
+		Username:  input.Username,
+		Password:  hashed,
+		Email:     input.Email,
+		Role:      models.RoleAuthor,
+		CreatedAt: time.Now(),
+	}
+	sp_create := NewSubSpan(sp, "Store.CreateUser()")
+	err = h.users.CreateUser(c.Request.Context(), &user)
+	sp_create.Finish()
+	if errors.Is(err, store.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sp_res := NewSubSpan(sp, "c.JSON()")
+	c.JSON(http.StatusCreated, UserOutput{
+		Username:  user.Username,
+		Email:     user.Email,
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt,
+	})
+	sp_res.Finish()
+}
+
+// SignOutHandler clears the session and, if a valid bearer access token is
+// given, revokes the access and refresh tokens of that sign-in.
 func (h *AuthHandler) SignOutHandler(c *gin.Context) {
 	span := opengintracing.MustGetSpan(c)
 	sp := NewSubSpan(span, "SignOutHandler")
+	if tokenValue, ok := bearerToken(c); ok && h.cfg.JWTEnabled() {
+		sp_revoke := NewSubSpan(sp, "RevokeTokens")
+		claims, err := h.parseToken(tokenValue, accessToken)
+		if err == nil {
+			err = h.refreshTokens.RevokeFamily(claims.Username, claims.Family)
+		}
+		sp_revoke.Finish()
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			sp.Finish()
+			return
+		}
+	}
 	sp_session := NewSubSpan(sp, "ClearSession")
 	session := sessions.Default(c)
+	if id, ok := session.Get("token").(string); ok {
+		username, _ := session.Get("username").(string)
+		if err := h.sessions.Revoke(username, id); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+			log.Printf("While revoking session of %s: %s", username, err.Error())
+		}
+	}
 	session.Clear()
 	session.Save()
 	sp_session.Finish()
@@ -55,134 +179,240 @@ func (h *AuthHandler) SignOutHandler(c *gin.Context) {
 
 func (h *AuthHandler) SignInHandler(c *gin.Context) {
 	span := opengintracing.MustGetSpan(c)
-	sp := NewSubSpan(span, "UpdateRecipeHandler")
+	sp := NewSubSpan(span, "SignInHandler")
 	defer sp.Finish()
 	var user models.User
 	sp_json := NewSubSpan(sp, "BindJSON(user)")
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		sp_json.Finish()
+		return
 	}
 	sp_json.Finish()
+	sp_throttle := NewSubSpan(sp, "Throttle.Check()")
+	throttled := h.throttled(c, user.Username)
+	sp_throttle.Finish()
+	if throttled {
+		return
+	}
 	sp_auth := NewSubSpan(sp, "AuthUser")
-	hash := sha256.New()
-	sp_mdb := NewSubSpan(sp_auth, "MongoDB.FindUser()")
-	cur := h.collection.FindOne(h.ctx, bson.M{
-		"username": user.Username,
-		"password": string(hash.Sum([]byte(user.Password))),
-	})
+	sp_mdb := NewSubSpan(sp_auth, "Store.GetUser()")
+	stored, err := h.users.GetUser(c.Request.Context(), user.Username)
 	sp_mdb.Finish()
-	if cur.Err() != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+	if errors.Is(err, store.ErrUserNotFound) {
+		sp_verify := NewSubSpan(sp_auth, "password.VerifyDummy()")
+		password.VerifyDummy(user.Password)
+		sp_verify.Finish()
+		h.signInFailed(c, user.Username, reasonUnknownUser)
 		sp_auth.Finish()
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		sp_auth.Finish()
+		return
+	}
+	sp_verify := NewSubSpan(sp_auth, "password.Verify()")
+	ok, needsRehash, err := password.Verify(user.Password, stored.Password)
+	sp_verify.Finish()
+	if err != nil || !ok {
+		h.signInFailed(c, user.Username, reasonWrongPassword)
+		sp_auth.Finish()
+		return
+	}
+	if needsRehash {
+		sp_rehash := NewSubSpan(sp_auth, "Rehash")
+		h.rehash(user.Username, user.Password)
+		sp_rehash.Finish()
+	}
 	sp_auth.Finish()
-	/*sp_token := NewSubSpan(sp, "CreateToken")
-	expirationTime := time.Now().Add(10 * time.Minute)
-	claims := &Claims{
-		Username: user.Username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = ""
-	}
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	// the failed sign-ins are reset only after the second factor, so that
+	// knowing the password does not allow unlimited code guesses
+	if h.requireSecondFactor(c, sp, stored) {
+		return
+	}
+	if err := h.throttle.Reset(user.Username); err != nil {
+		log.Printf("While resetting failed sign-ins of %s: %s", user.Username, err.Error())
+	}
+	h.signIn(c, sp, user.Username)
+}
+
+// requireSecondFactor responds with a challenge for POST /signin/totp if
+// the user has enabled two-factor authentication, and reports whether it
+// responded.
+func (h *AuthHandler) requireSecondFactor(c *gin.Context, sp opentracing.Span, user models.User) bool {
+	if !user.TOTP.Enabled {
+		return false
+	}
+	sp_challenge := NewSubSpan(sp, "Challenges.Issue()")
+	challenge, err := h.challenges.Issue(user.Username)
+	sp_challenge.Finish()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Two-factor code required", "challenge": challenge})
+	return true
+}
+
+// signIn responds to a successful authentication of username: depending on
+// the auth mode it issues tokens and starts a session.
+func (h *AuthHandler) signIn(c *gin.Context, sp opentracing.Span, username string) {
+	var jwtOutput JWTOutput
+	if h.cfg.JWTEnabled() {
+		sp_token := NewSubSpan(sp, "CreateToken")
+		refresh, family, err := h.refreshTokens.Issue(username)
+		if err == nil {
+			jwtOutput, err = h.issueTokens(username, family, refresh)
+		}
 		sp_token.Finish()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
-	jwtOutput := JWTOutput{
-		Token:   tokenString,
-		Expires: expirationTime,
+	if h.cfg.SessionEnabled() {
+		sp_session := NewSubSpan(sp, "Session")
+		registered, err := h.sessions.Create(username, c.Request.UserAgent(), c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			sp_session.Finish()
+			return
+		}
+		session := sessions.Default(c)
+		session.Set("username", username)
+		session.Set("token", registered.ID)
+		session.Save()
+		sp_session.Finish()
 	}
-	sp_token.Finish()
-	*/
-	sp_session := NewSubSpan(sp, "Session")
-	sessionToken := xid.New().String()
-	session := sessions.Default(c)
-	session.Set("username", user.Username)
-	session.Set("token", sessionToken)
-	session.Save()
-	sp_session.Finish()
 	sp_res := NewSubSpan(sp, "c.JSON()")
-	c.JSON(http.StatusOK, gin.H{"message": "User signed in"})
+	if h.cfg.JWTEnabled() {
+		c.JSON(http.StatusOK, jwtOutput)
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "User signed in"})
+	}
 	sp_res.Finish()
 }
 
+// rehash upgrades the stored password hash of a user who just signed in
+// with a legacy or outdated hash. Failures are logged and do not prevent the sign-in.
+func (h *AuthHandler) rehash(username, plain string) {
+	hashed, err := password.Hash(plain)
+	if err == nil {
+		err = h.users.UpdatePassword(h.ctx, username, hashed)
+	}
+	if err != nil {
+		log.Printf("While upgrading password hash of %s: %s", username, err.Error())
+		return
+	}
+	log.Printf("Upgraded password hash of %s", username)
+}
+
+// AuthMiddleware accepts requests carrying a valid `X-API-Key` header, a
+// valid session or, depending on the auth mode, a valid `Authorization: Bearer`
+// access token. The name of the authenticated user is available through CurrentUser.
 func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		//tokenValue := c.GetHeader("Authorization")
-		session := sessions.Default(c)
-		sessionToken := session.Get("token")
-		/*claims := &Claims{}
-		tkn, err := jwt.ParseWithClaims(tokenValue, claims,
-			func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("JWT_SECRET")), nil
-			})
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			if h.authenticateAPIKey(c, key) {
+				c.Next()
+			}
+			return
+		}
+		if h.cfg.JWTEnabled() {
+			if tokenValue, ok := bearerToken(c); ok {
+				claims, err := h.parseToken(tokenValue, accessToken)
+				if err != nil {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+					c.Abort()
+					return
+				}
+				active, err := h.refreshTokens.FamilyActive(claims.Family)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					c.Abort()
+					return
+				}
+				if !active {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+					c.Abort()
+					return
+				}
+				c.Set(userKey, claims.Username)
+				c.Next()
+				return
+			}
 		}
-		if tkn == nil || !tkn.Valid {
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}*/
-		if sessionToken == nil {
-			c.JSON(http.StatusForbidden, gin.H{"message": "Not logged in"})
-			c.Abort()
+		if h.cfg.SessionEnabled() {
+			session := sessions.Default(c)
+			if id, ok := session.Get("token").(string); ok {
+				username, err := h.sessions.Touch(id, c.ClientIP())
+				if err == nil {
+					c.Set(userKey, username)
+					c.Set(sessionIDKey, id)
+					c.Next()
+					return
+				}
+				if !errors.Is(err, store.ErrSessionNotFound) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					c.Abort()
+					return
+				}
+				// the session has expired or been revoked
+				session.Clear()
+				session.Save()
+			}
 		}
-		c.Next()
+		c.JSON(http.StatusForbidden, gin.H{"message": "Not logged in"})
+		c.Abort()
 	}
 }
 
+// CurrentUser returns the name of the user authenticated by AuthMiddleware.
+func CurrentUser(c *gin.Context) string {
+	return c.GetString(userKey)
+}
+
+// RefreshHandler exchanges the refresh token given as `Authorization: Bearer`
+// header for a new access and refresh token pair. Each refresh token can be
+// used once; presenting it again revokes all tokens of the sign-in.
 func (h *AuthHandler) RefreshHandler(c *gin.Context) {
 	span := opengintracing.MustGetSpan(c)
 	sp := opentracing.StartSpan(
 		"RefreshHandler",
 		opentracing.ChildOf(span.Context()))
 	defer sp.Finish()
+	if !h.cfg.JWTEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "JWT authentication is disabled"})
+		return
+	}
 	sp_token := NewSubSpan(sp, "CreateToken")
-	tokenValue := c.GetHeader("Authorization")
-	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(tokenValue, claims,
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	tokenValue, ok := bearerToken(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
 		sp_token.Finish()
 		return
 	}
-	if tkn == nil || !tkn.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	username, family, next, err := h.refreshTokens.Rotate(tokenValue)
+	if errors.Is(err, store.ErrInvalidToken) || errors.Is(err, store.ErrTokenReused) {
+		if errors.Is(err, store.ErrTokenReused) {
+			log.Printf("Refresh token reuse for %s, revoked token family %s", username, family)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		sp_token.Finish()
 		return
 	}
-	if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > 30*time.Second {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is not expired yet"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		sp_token.Finish()
 		return
 	}
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims.ExpiresAt = expirationTime.Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = ""
-	}
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	jwtOutput, err := h.issueTokens(username, family, next)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		sp_token.Finish()
 		return
 	}
-	jwtOutput := JWTOutput{
-		Token:   tokenString,
-		Expires: expirationTime,
-	}
 	sp_token.Finish()
 	sp_res := NewSubSpan(sp, "c.JSON()")
 	c.JSON(http.StatusOK, jwtOutput)
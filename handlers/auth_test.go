@@ -0,0 +1,75 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignInRehashesLegacyPassword(t *testing.T) {
+	api := newTestAPI(t, nil)
+	err := api.users.CreateUser(context.Background(), &models.User{
+		Username: "packt",
+		Password: string(sha256.New().Sum([]byte(testPassword))),
+		Role:     models.RoleAuthor,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.signIn(t, "packt")
+	user, err := api.users.GetUser(context.Background(), "packt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Errorf("stored hash after sign-in = %q, want it upgraded to argon2id", user.Password)
+	}
+	api.signIn(t, "packt")
+}
+
+func TestSignInFailures(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleAuthor)
+	c := api.client(t)
+	wrong := c.expect(http.StatusUnauthorized, http.MethodPost, "/signin", gin.H{"username": "alice", "password": "wrong"})
+	unknown := c.expect(http.StatusUnauthorized, http.MethodPost, "/signin", gin.H{"username": "nobody", "password": "wrong"})
+	if string(wrong.body) != string(unknown.body) {
+		t.Errorf("unknown user answered %s, wrong password %s, want the same", unknown.body, wrong.body)
+	}
+}
+
+func TestSignInLockout(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleAuthor)
+	c := api.client(t)
+	wrong := gin.H{"username": "alice", "password": "wrong"}
+	right := gin.H{"username": "alice", "password": testPassword}
+	for i := 1; i <= api.cfg.Lockout.UserThreshold; i++ {
+		res := c.expect(http.StatusUnauthorized, http.MethodPost, "/signin", wrong)
+		if res.Header.Get("Retry-After") == "" {
+			t.Errorf("failure %d: no Retry-After header", i)
+		}
+		// even the right password is rejected while the username is blocked
+		res = c.expect(http.StatusTooManyRequests, http.MethodPost, "/signin", right)
+		if res.Header.Get("Retry-After") == "" {
+			t.Errorf("failure %d: 429 without Retry-After header", i)
+		}
+		if i < api.cfg.Lockout.UserThreshold {
+			api.redis.FastForward(api.cfg.Lockout.Backoff << uint(i-1))
+		}
+	}
+	res := c.expect(http.StatusTooManyRequests, http.MethodPost, "/signin", right)
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err != nil || time.Duration(seconds)*time.Second < api.cfg.Lockout.Duration-time.Minute {
+		t.Errorf("Retry-After = %q, want the lockout of %v", res.Header.Get("Retry-After"), api.cfg.Lockout.Duration)
+	}
+	api.redis.FastForward(api.cfg.Lockout.Duration)
+	api.signIn(t, "alice")
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/store"
+	"local/gin/gin-recipes-api/tagquery"
 	"log"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/gin-contrib/opengintracing"
@@ -15,100 +17,189 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type RecipesHandler struct {
-	collection  *mongo.Collection
+	store       store.RecipeStore
 	ctx         context.Context
 	redisClient *redis.Client
 }
 
-func NewRecipesHandler(ctx context.Context, col *mongo.Collection, redisClient *redis.Client) *RecipesHandler {
+func NewRecipesHandler(ctx context.Context, recipeStore store.RecipeStore, redisClient *redis.Client) *RecipesHandler {
 	return &RecipesHandler{
-		collection:  col,
+		store:       recipeStore,
 		ctx:         ctx,
 		redisClient: redisClient,
 	}
 }
 
 // swagger:operation GET /recipes recipes listRecipes
-// Returns list of recipes from backend
+// Returns a page of recipes from backend
 // ---
+// parameters:
+//   - name: limit
+//     in: query
+//     description: number of recipes per page, 1 to 100, defaults to 20
+//     type: integer
+//   - name: sort
+//     in: query
+//     description: publishedAt (default) or name, prefixed with - for descending order
+//     type: string
+//   - name: cursor
+//     in: query
+//     description: position to continue at, taken from the next link of the previous page
+//     type: string
+//   - name: fields
+//     in: query
+//     description: comma-separated list of fields to return
+//     type: string
 // produces:
 // - aplication/json
 // responses:
 //    '200':
 //         description: Sucessful operation
+//    '400':
+//         description: Invalid query parameter
 func (h *RecipesHandler) ListRecipesHandler(c *gin.Context) {
 	span := opengintracing.MustGetSpan(c)
 	sp := opentracing.StartSpan(
 		"ListRecipesHandler",
 		opentracing.ChildOf(span.Context()))
 	defer sp.Finish()
+	req, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	sp_redis := opentracing.StartSpan(
 		"RedisCache.Find",
 		opentracing.ChildOf(sp.Context()))
-	val, err := h.redisClient.Get("recipes").Result()
+	key, err := h.pageKey(req)
+	var val string
+	if err == nil {
+		val, err = h.redisClient.Get(key).Result()
+	}
 	sp_redis.Finish()
+	var page cachedPage
 	if err == redis.Nil {
-		log.Printf("reqeust to Mongo")
+		log.Printf("reqeust to store")
 
 		sp_find := opentracing.StartSpan(
-			"MongoDB.Find",
+			"Store.ListPage",
 			opentracing.ChildOf(sp.Context()))
-		cur, err := h.collection.Find(h.ctx, bson.M{})
+		result, err := h.store.ListPage(c.Request.Context(), req.query)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			sp_find.Finish()
 			return
 		}
 		sp_find.Finish()
-		defer cur.Close(h.ctx)
-		recipes := make([]models.Recipe, 0)
-		sp_for := opentracing.StartSpan(
-			"LoopMongoDBResult",
-			opentracing.ChildOf(sp.Context()))
-		for cur.Next(h.ctx) {
-			var recipe models.Recipe
-			err = cur.Decode(&recipe)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				sp_for.Finish()
-				return
-			}
-			recipes = append(recipes, recipe)
+		page = cachedPage{Recipes: result.Recipes, Total: result.Total}
+		if result.Next != nil {
+			page.Next = encodeCursor(req.sort, result.Next)
 		}
-		sp_for.Finish()
 		sp_redis := opentracing.StartSpan(
 			"RedisPutIntoCache",
 			opentracing.ChildOf(sp.Context()))
-		data, _ := json.Marshal(recipes)
-		h.redisClient.Set("recipes", string(data), 30*time.Minute)
+		data, _ := json.Marshal(page)
+		h.redisClient.Set(key, string(data), 30*time.Minute)
 		sp_redis.Finish()
-		sp_res := opentracing.StartSpan(
-			"c.JSON()",
-			opentracing.ChildOf(sp.Context()))
-		c.JSON(http.StatusOK, recipes)
-		sp_res.Finish()
 	} else if err != nil {
 		sp_res := opentracing.StartSpan(
 			"c.JSON()",
 			opentracing.ChildOf(sp.Context()))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		sp_res.Finish()
+		return
 	} else {
 		log.Printf("Request to Redis")
-		sp_res := opentracing.StartSpan(
-			"c.JSON()",
-			opentracing.ChildOf(sp.Context()))
-		recipes := make([]models.Recipe, 0)
-		json.Unmarshal([]byte(val), &recipes)
-		c.JSON(http.StatusOK, recipes)
+		json.Unmarshal([]byte(val), &page)
+	}
+	sp_res := opentracing.StartSpan(
+		"c.JSON()",
+		opentracing.ChildOf(sp.Context()))
+	setPageHeaders(c, req, page)
+	c.JSON(http.StatusOK, projectFields(page.Recipes, req.fields))
+	sp_res.Finish()
+}
+
+// swagger:operation GET /recipes/{id} recipes getRecipe
+// Returns a single recipe
+// ---
+// parameters:
+// - name: id
+//   in: path
+//   description: ID of recipe
+//   required: true
+//   type: string
+// - name: servings
+//   in: query
+//   description: Number of servings to scale the ingredients to
+//   required: false
+//   type: integer
+// produces:
+// - application/json
+// responses:
+//     '200':
+//         description: Successful operation
+//     '400':
+//         description: Malformed recipe ID or servings, or recipe without servings
+//     '404':
+//         description: Recipe not found
+func (h *RecipesHandler) GetRecipeHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := opentracing.StartSpan(
+		"GetRecipeHandler",
+		opentracing.ChildOf(span.Context()))
+	defer sp.Finish()
+	id := c.Param("id")
+	servings, err := parseServings(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sp_redis := NewSubSpan(sp, "RedisCache.Find")
+	val, err := h.redisClient.Get(recipeKey(id)).Result()
+	sp_redis.Finish()
+	if err == nil {
+		var recipe models.Recipe
+		if err := json.Unmarshal([]byte(val), &recipe); err == nil {
+			h.writeScaledRecipe(c, sp, recipe, servings)
+			return
+		}
+	} else if err != redis.Nil {
+		log.Println(errors.Wrap(err, "While reading recipe from cache").Error())
+	}
+
+	sp_find := NewSubSpan(sp, "Store.Get")
+	recipe, err := h.store.Get(c.Request.Context(), id)
+	sp_find.Finish()
+	if err != nil {
+		sp_res := NewSubSpan(sp, "c.JSON()")
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		sp_res.Finish()
+		return
+	}
+	sp_cache := NewSubSpan(sp, "RedisPutIntoCache")
+	data, _ := json.Marshal(recipe)
+	h.redisClient.Set(recipeKey(id), string(data), 30*time.Minute)
+	sp_cache.Finish()
+	h.writeScaledRecipe(c, sp, recipe, servings)
+}
+
+// writeScaledRecipe responds with the recipe scaled to servings, or as
+// stored if servings is 0.
+func (h *RecipesHandler) writeScaledRecipe(c *gin.Context, sp opentracing.Span, recipe models.Recipe, servings int) {
+	sp_scale := NewSubSpan(sp, "ScaleRecipe")
+	recipe, err := scaleRecipe(recipe, servings)
+	sp_scale.Finish()
+	sp_res := NewSubSpan(sp, "c.JSON()")
+	defer sp_res.Finish()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, recipe)
 }
 
 // swagger:operation PUT /recipes/{id} recipes updateRecipes
@@ -145,35 +236,34 @@ func (h *RecipesHandler) UpdateRecipeHandler(c *gin.Context) {
 		sp_json.Finish()
 		return
 	}
-	rID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("ID is not valid: %s", err.Error())})
-		sp_json.Finish()
+	sp_json.Finish()
+
+	sp_authz := opentracing.StartSpan(
+		"AuthorizeRecipe",
+		opentracing.ChildOf(sp.Context()))
+	if err := h.authorizeRecipe(c, id, models.PermUpdateAnyRecipe); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		sp_authz.Finish()
 		return
 	}
-	recipe.ID = rID
-	sp_json.Finish()
+	sp_authz.Finish()
 
 	sp_update := opentracing.StartSpan(
-		"MongoDB.UpdateOne",
+		"Store.Update",
 		opentracing.ChildOf(sp.Context()))
-	_, err = h.collection.UpdateOne(h.ctx, bson.M{"_id": rID}, bson.D{{"$set", bson.D{
-		{"name", recipe.Name},
-		{"tags", recipe.Tags},
-		{"ingredients", recipe.Ingredients},
-		{"instructions", recipe.Instructions},
-	}}})
+	recipe.UpdatedBy = CurrentUser(c)
+	err := h.store.Update(c.Request.Context(), id, &recipe)
 	sp_update.Finish()
 	if err != nil {
 		log.Println(err.Error())
 		sp_res := opentracing.StartSpan(
 			"c.JSON()",
 			opentracing.ChildOf(sp.Context()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		sp_res.Finish()
 		return
 	}
-	h.redisClient.Del("recipes")
+	h.invalidateRecipes(id)
 	sp_res := opentracing.StartSpan(
 		"c.JSON()",
 		opentracing.ChildOf(sp.Context()))
@@ -209,11 +299,11 @@ func (h *RecipesHandler) NewRecipeHandler(c *gin.Context) {
 	}
 	sp_json.Finish()
 	sp_ins := opentracing.StartSpan(
-		"MongoDB.InsertOne",
+		"Store.Create",
 		opentracing.ChildOf(sp.Context()))
-	recipe.ID = primitive.NewObjectID()
-	recipe.PublishedAt = time.Now()
-	_, err := h.collection.InsertOne(h.ctx, recipe)
+	recipe.CreatedBy = CurrentUser(c)
+	recipe.UpdatedBy = recipe.CreatedBy
+	err := h.store.Create(c.Request.Context(), &recipe)
 	if err != nil {
 		log.Println(err.Error())
 		sp_res := opentracing.StartSpan(
@@ -225,7 +315,7 @@ func (h *RecipesHandler) NewRecipeHandler(c *gin.Context) {
 		sp_res.Finish()
 		return
 	}
-	h.redisClient.Del("recipes")
+	h.invalidateRecipes(recipe.ID.Hex())
 	sp_ins.Finish()
 	sp_res := opentracing.StartSpan(
 		"c.JSON()",
@@ -257,78 +347,177 @@ func (h *RecipesHandler) DeleteRecipeHandler(c *gin.Context) {
 		opentracing.ChildOf(span.Context()))
 	defer sp.Finish()
 	id := c.Param("id")
-	rID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		sp_res := opentracing.StartSpan("c.JSON()", opentracing.ChildOf(sp.Context()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("ID is not valid: %s", err.Error())})
-		sp_res.Finish()
+	sp_authz := opentracing.StartSpan("AuthorizeRecipe", opentracing.ChildOf(sp.Context()))
+	if err := h.authorizeRecipe(c, id, models.PermDeleteAnyRecipe); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		sp_authz.Finish()
 		return
 	}
-	sp_del := opentracing.StartSpan("MongoDB.DeleteOne", opentracing.ChildOf(sp.Context()))
-	_, err = h.collection.DeleteOne(h.ctx, bson.M{"_id": rID})
+	sp_authz.Finish()
+	sp_del := opentracing.StartSpan("Store.Delete", opentracing.ChildOf(sp.Context()))
+	err := h.store.Delete(c.Request.Context(), id)
 	sp_del.Finish()
 	if err != nil {
 		sp_res := opentracing.StartSpan("c.JSON()", opentracing.ChildOf(sp.Context()))
-		c.JSON(http.StatusNotFound, gin.H{"error": "Recipe not found"})
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		sp_res.Finish()
 		return
 	}
-	h.redisClient.Del("recipes")
+	h.invalidateRecipes(id)
 	sp_res := opentracing.StartSpan("c.JSON()", opentracing.ChildOf(sp.Context()))
 	c.JSON(http.StatusOK, gin.H{"message": "Recipe has been deleted"})
 	sp_res.Finish()
 }
 
+// swagger:operation GET /users/{username}/recipes recipes listUserRecipes
+// Returns the recipes created by a user
+// ---
+// produces:
+// - application/json
+// parameters:
+//   - name: username
+//     in: path
+//     description: name of the user
+//     required: true
+//     type: string
+// responses:
+//     '200':
+//         description: Successful operation
+func (h *RecipesHandler) ListUserRecipesHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := opentracing.StartSpan(
+		"ListUserRecipesHandler",
+		opentracing.ChildOf(span.Context()))
+	defer sp.Finish()
+	sp_find := opentracing.StartSpan(
+		"Store.ListByAuthor",
+		opentracing.ChildOf(sp.Context()))
+	recipes, err := h.store.ListByAuthor(c.Request.Context(), c.Param("username"))
+	sp_find.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sp_res := opentracing.StartSpan(
+		"c.JSON()",
+		opentracing.ChildOf(sp.Context()))
+	c.JSON(http.StatusOK, recipes)
+	sp_res.Finish()
+}
+
+// recipeKey is the Redis key caching the recipe with the given ID.
+func recipeKey(id string) string {
+	return "recipe:" + id
+}
+
+// invalidateRecipes drops the cached pages of the recipe listing and the
+// cached copy of the recipe with the given ID.
+func (h *RecipesHandler) invalidateRecipes(id string) {
+	h.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Incr(recipesGenerationKey)
+		pipe.Del(recipeKey(id))
+		return nil
+	})
+}
+
+// authorizeRecipe checks that the current user may modify the recipe with
+// the given ID, either because the role grants anyPerm or because the user
+// created the recipe.
+func (h *RecipesHandler) authorizeRecipe(c *gin.Context, id string, anyPerm models.Permission) error {
+	if HasPermission(c, anyPerm) {
+		return nil
+	}
+	recipe, err := h.store.Get(c.Request.Context(), id)
+	if err != nil {
+		return err
+	}
+	if recipe.CreatedBy == "" || recipe.CreatedBy != CurrentUser(c) {
+		return ErrForbidden
+	}
+	return nil
+}
+
 // swagger:operation GET /recipes/search recipes findRecipe
-// Search recipes based on tags
+// Search recipes based on tags or full text
 // ---
 // produces:
 // - application/json
 // parameters:
 //   - name: tag
 //     in: query
-//     description: recipe tag
-//     required: true
+//     description: tag query like vegetarian AND (dinner OR lunch) AND NOT spicy
+//     type: string
+//   - name: q
+//     in: query
+//     description: full-text query with words, "phrases" and -negated terms
 //     type: string
+//   - name: limit
+//     in: query
+//     description: maximum number of full-text matches, 1 to 100, defaults to 20
+//     type: integer
 // responses:
 //     '200':
 //         description: Successful operation
+//     '400':
+//         description: Invalid query
 func (h *RecipesHandler) SearchRecipeHandler(c *gin.Context) {
 	span := opengintracing.MustGetSpan(c)
 	sp := opentracing.StartSpan(
 		"ListRecipesHandler",
 		opentracing.ChildOf(span.Context()))
 	defer sp.Finish()
+	if q, ok := c.GetQuery("q"); ok {
+		h.searchText(c, sp, q)
+		return
+	}
 	sp_find := opentracing.StartSpan(
-		"MongoDB.Find",
+		"Store.Search",
 		opentracing.ChildOf(sp.Context()))
-	tags := strings.Split(c.Query("tag"), ";")
-	cur, err := h.collection.Find(h.ctx, bson.M{"tags": bson.M{"$in": tags}})
+	query, err := tagquery.Parse(c.Query("tag"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		sp_find.Finish()
+		return
+	}
+	recipes, err := h.store.Search(c.Request.Context(), query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		sp_find.Finish()
 		return
 	}
 	sp_find.Finish()
-	defer cur.Close(h.ctx)
-	recipes := make([]models.Recipe, 0)
-	sp_for := opentracing.StartSpan(
-		"LoopMongoDBResult",
-		opentracing.ChildOf(sp.Context()))
-	for cur.Next(h.ctx) {
-		var recipe models.Recipe
-		err = cur.Decode(&recipe)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			sp_for.Finish()
-			return
-		}
-		recipes = append(recipes, recipe)
-	}
-	sp_for.Finish()
 	sp_res := opentracing.StartSpan(
 		"c.JSON()",
 		opentracing.ChildOf(sp.Context()))
 	c.JSON(http.StatusOK, recipes)
 	sp_res.Finish()
 }
+
+// searchText answers a full-text search with the matching recipes and
+// their scores, best match first.
+func (h *RecipesHandler) searchText(c *gin.Context, sp opentracing.Span, q string) {
+	query := store.ParseTextQuery(q)
+	if query.Empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q needs at least one word or phrase that is not negated"})
+		return
+	}
+	limit := defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxPageLimit)})
+			return
+		}
+		limit = n
+	}
+	sp_find := NewSubSpan(sp, "Store.SearchText")
+	matches, err := h.store.SearchText(c.Request.Context(), query, limit)
+	sp_find.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sp_res := NewSubSpan(sp, "c.JSON()")
+	c.JSON(http.StatusOK, matches)
+	sp_res.Finish()
+}
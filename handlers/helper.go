@@ -1,7 +1,29 @@
 package handlers
 
-import "github.com/opentracing/opentracing-go"
+import (
+	"local/gin/gin-recipes-api/store"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+)
 
 func NewSubSpan(sp opentracing.Span, name string) opentracing.Span {
 	return opentracing.StartSpan(name, opentracing.ChildOf(sp.Context()))
 }
+
+// ErrForbidden is returned when the current user may not access a resource.
+var ErrForbidden = errors.New("Permission denied")
+
+// storeErrorStatus maps errors returned by a store.RecipeStore to HTTP status codes.
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
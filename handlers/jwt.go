@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+const accessToken = "access"
+
+// issueTokens signs a new access token for the given token family and
+// pairs it with the opaque refresh token of that family.
+func (h *AuthHandler) issueTokens(username, family, refresh string) (JWTOutput, error) {
+	var err error
+	out := JWTOutput{
+		Expires:        time.Now().Add(h.cfg.AccessTokenTTL),
+		RefreshToken:   refresh,
+		RefreshExpires: time.Now().Add(h.cfg.RefreshTokenTTL),
+	}
+	claims := &Claims{
+		Username: username,
+		Type:     accessToken,
+		Family:   family,
+		StandardClaims: jwt.StandardClaims{
+			Id:        xid.New().String(),
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: out.Expires.Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	out.Token, err = token.SignedString([]byte(h.cfg.JWTSecret))
+	return out, err
+}
+
+// parseToken validates the signature and expiry of tokenValue and checks
+// that it is of the given type.
+func (h *AuthHandler) parseToken(tokenValue, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	tkn, err := jwt.ParseWithClaims(tokenValue, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return []byte(h.cfg.JWTSecret), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if tkn == nil || !tkn.Valid {
+		return nil, errors.New("Invalid token")
+	}
+	if claims.Type != tokenType {
+		return nil, errors.Errorf("Token type must be %q", tokenType)
+	}
+	return claims, nil
+}
+
+// bearerToken returns the token of an `Authorization: Bearer <token>` header.
+func bearerToken(c *gin.Context) (string, bool) {
+	value := c.GetHeader("Authorization")
+	const prefix = "Bearer "
+	if len(value) <= len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return "", false
+	}
+	return value[len(prefix):], true
+}
@@ -0,0 +1,108 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/handlers"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func authMode(mode string) func(cfg *config.Config, url string) {
+	return func(cfg *config.Config, url string) {
+		cfg.Auth.Mode = mode
+	}
+}
+
+// signInJWT signs in as username and returns the issued tokens.
+func (api *testAPI) signInJWT(t *testing.T, username string) handlers.JWTOutput {
+	t.Helper()
+	var out handlers.JWTOutput
+	api.client(t).expect(http.StatusOK, http.MethodPost, "/signin", gin.H{"username": username, "password": testPassword}).decode(t, &out)
+	if out.Token == "" || out.RefreshToken == "" {
+		t.Fatalf("sign-in returned %+v, want an access and a refresh token", out)
+	}
+	return out
+}
+
+// bearer returns a client sending token as bearer token and no cookies.
+func (api *testAPI) bearer(t *testing.T, token string) *client {
+	c := api.client(t)
+	c.header.Set("Authorization", "Bearer "+token)
+	return c
+}
+
+// signToken signs claims like the API does, with the given secret.
+func signToken(t *testing.T, claims *handlers.Claims, method jwt.SigningMethod, secret interface{}) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTAuth(t *testing.T) {
+	api := newTestAPI(t, authMode(config.AuthModeJWT))
+	api.signUp(t, "alice", models.RoleAuthor)
+	out := api.signInJWT(t, "alice")
+	recipe := gin.H{"name": "Soup"}
+	api.bearer(t, out.Token).expect(http.StatusOK, http.MethodPost, "/recipes", recipe)
+	api.client(t).expect(http.StatusForbidden, http.MethodPost, "/recipes", recipe)
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(out.Token, &handlers.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := *parsed.Claims.(*handlers.Claims)
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	wrongType := valid
+	wrongType.Type = "refresh"
+	for name, token := range map[string]string{
+		"other secret": signToken(t, &valid, jwt.SigningMethodHS256, []byte("other-secret")),
+		"expired":      signToken(t, &expired, jwt.SigningMethodHS256, []byte(api.cfg.Auth.JWTSecret)),
+		"wrong type":   signToken(t, &wrongType, jwt.SigningMethodHS256, []byte(api.cfg.Auth.JWTSecret)),
+		"unsigned":     signToken(t, &valid, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		"refresh":      out.RefreshToken,
+	} {
+		res := api.bearer(t, token).do(http.MethodPost, "/recipes", recipe)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s token: POST /recipes = %d %s, want 401", name, res.StatusCode, res.body)
+		}
+	}
+
+	// signing out revokes the access token before it expires
+	api.bearer(t, out.Token).expect(http.StatusOK, http.MethodPost, "/signout", nil)
+	api.bearer(t, out.Token).expect(http.StatusUnauthorized, http.MethodPost, "/recipes", recipe)
+}
+
+func TestJWTDisabledInSessionMode(t *testing.T) {
+	api := newTestAPI(t, authMode(config.AuthModeSession))
+	api.signUp(t, "alice", models.RoleAuthor)
+	c := api.signIn(t, "alice")
+	c.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+
+	token := signToken(t, &handlers.Claims{
+		Username:       "alice",
+		Type:           "access",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	}, jwt.SigningMethodHS256, []byte(api.cfg.Auth.JWTSecret))
+	api.bearer(t, token).expect(http.StatusForbidden, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	api.bearer(t, token).expect(http.StatusNotFound, http.MethodPost, "/refresh", nil)
+}
+
+func TestJWTAndSessionsInBothMode(t *testing.T) {
+	api := newTestAPI(t, authMode(config.AuthModeBoth))
+	api.signUp(t, "alice", models.RoleAuthor)
+	c := api.client(t)
+	var out handlers.JWTOutput
+	c.expect(http.StatusOK, http.MethodPost, "/signin", gin.H{"username": "alice", "password": testPassword}).decode(t, &out)
+	c.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	api.bearer(t, out.Token).expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Stew"})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// signInFailures counts failed sign-ins; it is exposed on /metrics next to
+// the request metrics of ginprometheus.
+var signInFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "recipes",
+	Name:      "signin_failures_total",
+	Help:      "Number of failed sign-ins by reason.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(signInFailures)
+}
+
+// Reasons of failed sign-ins.
+const (
+	reasonUnknownUser   = "unknown_user"
+	reasonWrongPassword = "wrong_password"
+	reasonWrongCode     = "wrong_code"
+	reasonThrottled     = "throttled"
+)
+
+// throttled responds with 429 Too Many Requests if sign-ins for username
+// from the client IP are currently blocked.
+func (h *AuthHandler) throttled(c *gin.Context, username string) bool {
+	retryAfter, err := h.throttle.Check(username, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if retryAfter <= 0 {
+		return false
+	}
+	signInFailures.WithLabelValues(reasonThrottled).Inc()
+	tooManyAttempts(c, retryAfter)
+	return true
+}
+
+// signInFailed records a failed sign-in and responds with 401 Unauthorized.
+// Wrong passwords and unknown usernames get the same response.
+func (h *AuthHandler) signInFailed(c *gin.Context, username, reason string) {
+	signInFailures.WithLabelValues(reason).Inc()
+	retryAfter, err := h.throttle.Fail(username, c.ClientIP())
+	if err != nil {
+		log.Printf("While recording failed sign-in of %s: %s", username, err.Error())
+	}
+	if retryAfter > 0 {
+		c.Header("Retry-After", retryAfterSeconds(retryAfter))
+	}
+	if reason == reasonWrongCode {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+}
+
+func tooManyAttempts(c *gin.Context, retryAfter time.Duration) {
+	seconds := retryAfterSeconds(retryAfter)
+	c.Header("Retry-After", seconds)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign-ins, retry in " + seconds + "s"})
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
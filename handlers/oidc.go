@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/oidc"
+	"local/gin/gin-recipes-api/store"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Session keys holding the pending OIDC sign-in between login and callback.
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+)
+
+// OIDCLoginHandler starts the OpenID Connect authorization code flow by
+// redirecting to the identity provider. A `login_hint` query parameter is
+// passed on to the provider.
+func (h *AuthHandler) OIDCLoginHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "OIDCLoginHandler")
+	defer sp.Finish()
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	sp_session := NewSubSpan(sp, "Session")
+	session := sessions.Default(c)
+	session.Set(oidcStateKey, state)
+	session.Set(oidcNonceKey, nonce)
+	session.Set(oidcVerifierKey, verifier)
+	err := session.Save()
+	sp_session.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	location := h.provider.AuthCodeURL(state, nonce, verifier)
+	if hint := c.Query("login_hint"); hint != "" {
+		location += "&login_hint=" + url.QueryEscape(hint)
+	}
+	c.Redirect(http.StatusFound, location)
+}
+
+// OIDCCallbackHandler completes the OpenID Connect sign-in. The user linked
+// to the subject of the ID token is signed in like by SignInHandler,
+// including the two-factor challenge; a new user is created on the first
+// sign-in.
+func (h *AuthHandler) OIDCCallbackHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "OIDCCallbackHandler")
+	defer sp.Finish()
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider returned " + e + ": " + c.Query("error_description")})
+		return
+	}
+	sp_session := NewSubSpan(sp, "Session")
+	session := sessions.Default(c)
+	state, _ := session.Get(oidcStateKey).(string)
+	nonce, _ := session.Get(oidcNonceKey).(string)
+	verifier, _ := session.Get(oidcVerifierKey).(string)
+	session.Delete(oidcStateKey)
+	session.Delete(oidcNonceKey)
+	session.Delete(oidcVerifierKey)
+	session.Save()
+	sp_session.Finish()
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired OIDC state"})
+		return
+	}
+	sp_exchange := NewSubSpan(sp, "Provider.Exchange()")
+	identity, err := h.provider.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+	sp_exchange.Finish()
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	sp_user := NewSubSpan(sp, "OIDCUser")
+	user, err := h.oidcUser(c.Request.Context(), identity)
+	sp_user.Finish()
+	if errors.Is(err, store.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username " + identity.PreferredUsername + " is already taken by another user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if h.requireSecondFactor(c, sp, user) {
+		return
+	}
+	h.signIn(c, sp, user.Username)
+}
+
+// oidcUser returns the user linked to the OIDC subject, creating it with the
+// preferred username of the identity on the first sign-in. Existing users
+// with that username are never linked automatically.
+func (h *AuthHandler) oidcUser(ctx context.Context, identity oidc.Identity) (models.User, error) {
+	user, err := h.users.GetUserBySubject(ctx, identity.Subject)
+	if !errors.Is(err, store.ErrUserNotFound) {
+		return user, err
+	}
+	if !usernamePattern.MatchString(identity.PreferredUsername) {
+		return user, errors.Errorf("identity provider supplied no valid username for subject %s", identity.Subject)
+	}
+	user = models.User{
+		Username:  identity.PreferredUsername,
+		Email:     identity.Email,
+		Role:      models.RoleAuthor,
+		CreatedAt: time.Now(),
+		Subject:   identity.Subject,
+	}
+	err = h.users.CreateUser(ctx, &user)
+	return user, err
+}
@@ -0,0 +1,190 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/oidc/oidctest"
+	"local/gin/gin-recipes-api/store"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// newOIDCTestAPI starts the API signing in through an oidctest provider
+// whose ID tokens are changed by claims, if not nil.
+func newOIDCTestAPI(t *testing.T, claims func(jwt.MapClaims)) *testAPI {
+	t.Helper()
+	provider, err := oidctest.NewServer("", "recipes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Claims = claims
+	ts := httptest.NewServer(provider)
+	t.Cleanup(ts.Close)
+	provider.Issuer = ts.URL
+	return newTestAPI(t, func(cfg *config.Config, url string) {
+		cfg.OIDC.Issuer = ts.URL
+		cfg.OIDC.ClientID = "recipes"
+		cfg.OIDC.RedirectURL = url + "/oidc/callback"
+	})
+}
+
+// oidcLogin starts the sign-in at the API, signs in at the provider as
+// subject and returns the callback URL the provider redirects to.
+func oidcLogin(c *client, subject string) *url.URL {
+	c.t.Helper()
+	res := c.expect(http.StatusFound, http.MethodGet, "/oidc/login?login_hint="+url.QueryEscape(subject), nil)
+	res = c.expect(http.StatusFound, http.MethodGet, res.Header.Get("Location"), nil)
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return callback
+}
+
+// withQuery returns u with the query parameter key set to value.
+func withQuery(u *url.URL, key, value string) string {
+	q := u.Query()
+	q.Set(key, value)
+	copy := *u
+	copy.RawQuery = q.Encode()
+	return copy.String()
+}
+
+func TestOIDCCallbackCreatesUser(t *testing.T) {
+	api := newOIDCTestAPI(t, nil)
+	c := api.client(t)
+	callback := oidcLogin(c, "alice")
+	c.expect(http.StatusOK, http.MethodGet, callback.String(), nil)
+	user, err := api.users.GetUser(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Subject != "alice" || user.Email != "alice@example.com" || user.Role != models.RoleAuthor {
+		t.Errorf("created user %+v, want subject alice, alice@example.com and role author", user)
+	}
+	c.expect(http.StatusOK, http.MethodGet, "/users/me/sessions", nil)
+
+	// the state is used up by the first callback
+	c.expect(http.StatusBadRequest, http.MethodGet, callback.String(), nil)
+}
+
+func TestOIDCCallbackMapsSubject(t *testing.T) {
+	api := newOIDCTestAPI(t, nil)
+	err := api.users.CreateUser(context.Background(), &models.User{
+		Username: "carol",
+		Role:     models.RoleAuthor,
+		Subject:  "c-123",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := api.client(t)
+	c.expect(http.StatusOK, http.MethodGet, oidcLogin(c, "c-123").String(), nil)
+	if _, err := api.users.GetUser(context.Background(), "c-123"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Errorf("sign-in of a linked subject created user c-123")
+	}
+	res := c.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	var recipe models.Recipe
+	res.decode(t, &recipe)
+	if recipe.CreatedBy != "carol" {
+		t.Errorf("recipe created by %q, want the linked user carol", recipe.CreatedBy)
+	}
+}
+
+func TestOIDCCallbackNeverLinksExistingUser(t *testing.T) {
+	api := newOIDCTestAPI(t, nil)
+	api.signUp(t, "bob", models.RoleAuthor)
+	c := api.client(t)
+	c.expect(http.StatusConflict, http.MethodGet, oidcLogin(c, "bob").String(), nil)
+	user, err := api.users.GetUser(context.Background(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Subject != "" {
+		t.Errorf("existing user bob was linked to subject %q", user.Subject)
+	}
+	c.expect(http.StatusForbidden, http.MethodGet, "/users/me/sessions", nil)
+}
+
+func TestOIDCCallbackRejectsState(t *testing.T) {
+	api := newOIDCTestAPI(t, nil)
+	c := api.client(t)
+	callback := oidcLogin(c, "alice")
+	c.expect(http.StatusBadRequest, http.MethodGet, withQuery(callback, "state", "forged"), nil)
+	// a failed callback ends the pending sign-in
+	c.expect(http.StatusBadRequest, http.MethodGet, callback.String(), nil)
+
+	// the state is bound to the session that started the sign-in
+	callback = oidcLogin(api.client(t), "alice")
+	other := api.client(t)
+	other.expect(http.StatusBadRequest, http.MethodGet, callback.String(), nil)
+	if _, err := api.users.GetUser(context.Background(), "alice"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Errorf("rejected callbacks created user alice")
+	}
+}
+
+func TestOIDCCallbackRejectsVerifier(t *testing.T) {
+	api := newOIDCTestAPI(t, nil)
+	victim, attacker := api.client(t), api.client(t)
+	stolen := oidcLogin(victim, "alice")
+	own := oidcLogin(attacker, "mallory")
+	// the code of another sign-in does not match the attacker's PKCE verifier
+	attacker.expect(http.StatusUnauthorized, http.MethodGet, withQuery(own, "code", stolen.Query().Get("code")), nil)
+	if _, err := api.users.GetUser(context.Background(), "alice"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Errorf("callback with a stolen code created user alice")
+	}
+}
+
+func TestOIDCCallbackRejectsIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(jwt.MapClaims)
+	}{
+		{"nonce", func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }},
+		{"missing nonce", func(claims jwt.MapClaims) { delete(claims, "nonce") }},
+		{"expired", func(claims jwt.MapClaims) {
+			claims["iat"] = time.Now().Add(-time.Hour).Unix()
+			claims["exp"] = time.Now().Add(-10 * time.Minute).Unix()
+		}},
+		{"audience", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		{"shared audience", func(claims jwt.MapClaims) { claims["aud"] = []string{"recipes", "another-client"} }},
+		{"issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{"subject", func(claims jwt.MapClaims) { claims["sub"] = "" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newOIDCTestAPI(t, test.claims)
+			c := api.client(t)
+			c.expect(http.StatusUnauthorized, http.MethodGet, oidcLogin(c, "alice").String(), nil)
+			if _, err := api.users.GetUser(context.Background(), "alice"); !errors.Is(err, store.ErrUserNotFound) {
+				t.Errorf("rejected ID token created user alice")
+			}
+			c.expect(http.StatusForbidden, http.MethodGet, "/users/me/sessions", nil)
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresSecondFactor(t *testing.T) {
+	api := newOIDCTestAPI(t, nil)
+	c := api.client(t)
+	c.expect(http.StatusOK, http.MethodGet, oidcLogin(c, "alice").String(), nil)
+	err := api.users.UpdateTOTP(context.Background(), "alice", models.TOTP{Secret: "JBSWY3DPEHPK3PXP", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = api.client(t)
+	res := c.expect(http.StatusAccepted, http.MethodGet, oidcLogin(c, "alice").String(), nil)
+	if res.field(t, "challenge") == "" {
+		t.Errorf("response %s has no challenge", res.body)
+	}
+	c.expect(http.StatusForbidden, http.MethodGet, "/users/me/sessions", nil)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/store"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPageLimit is the page size of GET /recipes without a limit.
+	defaultPageLimit = 20
+	// maxPageLimit caps the page size clients may ask for.
+	maxPageLimit = 100
+	// recipesGenerationKey counts the changes to recipes. Cached pages are
+	// keyed by the generation they were read in, so a change makes all of
+	// them stale at once; they expire on their own.
+	recipesGenerationKey = "recipes_generation"
+)
+
+// recipeFields are the JSON fields of a recipe that can be selected with
+// the fields query parameter.
+var recipeFields = map[string]bool{
+	"id":           true,
+	"name":         true,
+	"tags":         true,
+	"ingredients":  true,
+	"instructions": true,
+	"servings":     true,
+	"publishedAt":  true,
+	"createdBy":    true,
+	"updatedBy":    true,
+	"updatedAt":    true,
+}
+
+// pageRequest is a parsed request for a page of the recipe listing.
+type pageRequest struct {
+	query store.PageQuery
+	// sort and cursor are the query parameters as given by the client.
+	sort   string
+	cursor string
+	fields []string
+}
+
+// cachedPage is a page of the recipe listing as stored in Redis.
+type cachedPage struct {
+	Recipes []models.Recipe `json:"recipes"`
+	Total   int64           `json:"total"`
+	Next    string          `json:"next,omitempty"`
+}
+
+// cursorToken is the decoded form of the opaque cursor handed to clients.
+// It remembers the sort order, since a position only has a meaning within
+// the order it was taken from.
+type cursorToken struct {
+	Sort string `json:"sort"`
+	store.Cursor
+}
+
+// parsePageRequest reads the limit, sort, cursor and fields query parameters.
+func parsePageRequest(c *gin.Context) (pageRequest, error) {
+	req := pageRequest{
+		query: store.PageQuery{
+			SortBy: store.SortPublishedAt,
+			Limit:  defaultPageLimit,
+		},
+		sort:   c.DefaultQuery("sort", store.SortPublishedAt),
+		cursor: c.Query("cursor"),
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return req, errors.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		req.query.Limit = n
+	}
+	req.query.SortBy = strings.TrimPrefix(req.sort, "-")
+	req.query.Desc = strings.HasPrefix(req.sort, "-")
+	if !store.ValidSort(req.query.SortBy) {
+		return req, errors.Errorf("cannot sort by '%s', use '%s' or '%s'", req.sort, store.SortPublishedAt, store.SortName)
+	}
+	if req.cursor != "" {
+		cursor, err := decodeCursor(req.cursor, req.sort)
+		if err != nil {
+			return req, err
+		}
+		req.query.After = cursor
+	}
+	if fields := c.Query("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if !recipeFields[field] {
+				return req, errors.Errorf("unknown field '%s'", field)
+			}
+			req.fields = append(req.fields, field)
+		}
+	}
+	return req, nil
+}
+
+// pageKey returns the Redis key caching the requested page.
+func (h *RecipesHandler) pageKey(req pageRequest) (string, error) {
+	generation, err := h.redisClient.Get(recipesGenerationKey).Int64()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrap(err, "While reading recipes generation")
+	}
+	return fmt.Sprintf("recipes:%d:%s:%d:%s", generation, req.sort, req.query.Limit, req.cursor), nil
+}
+
+func encodeCursor(sort string, cursor *store.Cursor) string {
+	data, _ := json.Marshal(cursorToken{Sort: sort, Cursor: *cursor})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value, sort string) (*store.Cursor, error) {
+	invalid := errors.New("cursor is not valid")
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, invalid
+	}
+	var token cursorToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, invalid
+	}
+	if token.Sort != sort {
+		return nil, errors.Errorf("cursor belongs to sort order '%s'", token.Sort)
+	}
+	return &token.Cursor, nil
+}
+
+// projectFields reduces the recipes to the selected fields and their ID.
+// Without selected fields the recipes are returned as they are.
+func projectFields(recipes []models.Recipe, fields []string) interface{} {
+	if len(fields) == 0 {
+		return recipes
+	}
+	projected := make([]map[string]json.RawMessage, 0, len(recipes))
+	for _, recipe := range recipes {
+		data, _ := json.Marshal(recipe)
+		var all map[string]json.RawMessage
+		json.Unmarshal(data, &all)
+		selected := map[string]json.RawMessage{"id": all["id"]}
+		for _, field := range fields {
+			if value, ok := all[field]; ok {
+				selected[field] = value
+			}
+		}
+		projected = append(projected, selected)
+	}
+	return projected
+}
+
+// setPageHeaders announces the total number of recipes and links the first
+// and next page of the listing.
+func setPageHeaders(c *gin.Context, req pageRequest, page cachedPage) {
+	c.Header("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	links := make([]string, 0, 2)
+	if req.cursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="first"`, pageURL(c, "")))
+	}
+	if page.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(c, page.Next)))
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+}
+
+// pageURL returns the URL of the current request continuing at cursor.
+func pageURL(c *gin.Context, cursor string) string {
+	u := *c.Request.URL
+	query := u.Query()
+	query.Del("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
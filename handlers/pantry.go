@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"local/gin/gin-recipes-api/ingredient"
+	"local/gin/gin-recipes-api/models"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// PantryInput lists the ingredients on hand, e.g. "2 eggs" or "salt".
+type PantryInput struct {
+	Ingredients []string `json:"ingredients" binding:"required"`
+	// Limit is the maximum number of recipes returned, 1 to 100.
+	Limit int `json:"limit"`
+}
+
+// PantryMatch is a recipe together with how much of it can be cooked from
+// the pantry.
+type PantryMatch struct {
+	models.Recipe
+	// Coverage is the percentage of the recipe's ingredients in the pantry.
+	Coverage float64 `json:"coverage"`
+	// Missing are the ingredient lines not covered by the pantry.
+	Missing []string `json:"missing"`
+}
+
+// swagger:operation POST /recipes/cook recipes cookRecipes
+// Returns the recipes that can be cooked with the given ingredients
+// ---
+// produces:
+// - application/json
+// responses:
+//     '200':
+//         description: Recipes by percentage of available ingredients
+//     '400':
+//         description: Invalid input
+func (h *RecipesHandler) CookHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "CookHandler")
+	defer sp.Finish()
+	var input PantryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		err = errors.Wrapf(err, "While c.ShouldBindJSON()")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Limit == 0 {
+		input.Limit = defaultPageLimit
+	}
+	if input.Limit < 1 || input.Limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxPageLimit)})
+		return
+	}
+	pantry := ingredient.NewPantry(input.Ingredients)
+	if pantry.Len() == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ingredients must name at least one ingredient"})
+		return
+	}
+	sp_find := NewSubSpan(sp, "Store.List")
+	recipes, err := h.store.List(c.Request.Context())
+	sp_find.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sp_rank := NewSubSpan(sp, "RankByPantry")
+	matches := rankByPantry(recipes, pantry, input.Limit)
+	sp_rank.Finish()
+	sp_res := NewSubSpan(sp, "c.JSON()")
+	c.JSON(http.StatusOK, matches)
+	sp_res.Finish()
+}
+
+// rankByPantry returns up to limit recipes using at least one ingredient of
+// the pantry, sorted by coverage and then by the number of missing
+// ingredients. Lines without an ingredient, like section separators, are
+// not counted.
+func rankByPantry(recipes []models.Recipe, pantry ingredient.Pantry, limit int) []PantryMatch {
+	matches := make([]PantryMatch, 0)
+	for _, recipe := range recipes {
+		total, covered := 0, 0
+		missing := make([]string, 0)
+		for _, ing := range recipe.Ingredients {
+			if ingredient.Normalize(ing) == "" {
+				continue
+			}
+			total++
+			if pantry.Has(ing) {
+				covered++
+			} else {
+				missing = append(missing, ing.Text())
+			}
+		}
+		if covered == 0 {
+			continue
+		}
+		matches = append(matches, PantryMatch{
+			Recipe:   recipe,
+			Coverage: math.Round(1000*float64(covered)/float64(total)) / 10,
+			Missing:  missing,
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Coverage != matches[j].Coverage {
+			return matches[i].Coverage > matches[j].Coverage
+		}
+		return len(matches[i].Missing) < len(matches[j].Missing)
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"local/gin/gin-recipes-api/notify"
+	"local/gin/gin-recipes-api/password"
+	"local/gin/gin-recipes-api/store"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-gonic/gin"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+)
+
+// PasswordHandler lets users change a known password and reset a forgotten one.
+type PasswordHandler struct {
+	users         store.UserStore
+	refreshTokens *store.RefreshTokenStore
+	sessions      *store.SessionRegistry
+	resets        *store.PasswordResetStore
+	throttle      *store.SignInThrottle
+	notifier      notify.Notifier
+	resetURL      string
+	ctx           context.Context
+}
+
+func NewPasswordHandler(ctx context.Context, users store.UserStore, refreshTokens *store.RefreshTokenStore, sessions *store.SessionRegistry, resets *store.PasswordResetStore, throttle *store.SignInThrottle, notifier notify.Notifier, resetURL string) *PasswordHandler {
+	return &PasswordHandler{
+		users:         users,
+		refreshTokens: refreshTokens,
+		sessions:      sessions,
+		resets:        resets,
+		throttle:      throttle,
+		notifier:      notifier,
+		resetURL:      resetURL,
+		ctx:           ctx,
+	}
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type ForgotPasswordInput struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type ResetPasswordInput struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+// ChangePasswordHandler replaces the password of the current user, who has
+// to confirm the current password. Refresh tokens and all other sessions of
+// the user are revoked.
+func (h *PasswordHandler) ChangePasswordHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ChangePasswordHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	var input ChangePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := CurrentUser(c)
+	retryAfter, err := h.throttle.Check(username, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if retryAfter > 0 {
+		tooManyAttempts(c, retryAfter)
+		return
+	}
+	sp_user := NewSubSpan(sp, "Store.GetUser()")
+	user, err := h.users.GetUser(c.Request.Context(), username)
+	sp_user.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sp_verify := NewSubSpan(sp, "password.Verify()")
+	ok, _, err := password.Verify(input.CurrentPassword, user.Password)
+	sp_verify.Finish()
+	if err != nil || !ok {
+		signInFailures.WithLabelValues(reasonWrongPassword).Inc()
+		if _, err := h.throttle.Fail(username, c.ClientIP()); err != nil {
+			log.Printf("While recording failed password change of %s: %s", username, err.Error())
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is wrong"})
+		return
+	}
+	if err := password.Validate(input.NewPassword, username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.setPassword(c.Request.Context(), sp, username, input.NewPassword, currentSessionID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been changed"})
+}
+
+// ForgotPasswordHandler sends a password reset token to the email address
+// of the user. The response does not reveal whether the user exists.
+func (h *PasswordHandler) ForgotPasswordHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ForgotPasswordHandler")
+	defer sp.Finish()
+	var input ForgotPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	retryAfter, err := h.resets.Allow(input.Username, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if retryAfter > 0 {
+		seconds := retryAfterSeconds(retryAfter)
+		c.Header("Retry-After", seconds)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many reset requests, retry in " + seconds + "s"})
+		return
+	}
+	// delivery takes place in the background so that the response time
+	// does not reveal whether the user exists either
+	go h.sendResetToken(input.Username)
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the user exists, a reset token has been sent to its email address"})
+}
+
+func (h *PasswordHandler) sendResetToken(username string) {
+	user, err := h.users.GetUser(h.ctx, username)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return
+	}
+	if err == nil && user.Email == "" {
+		err = errors.New("user has no email address")
+	}
+	var token string
+	if err == nil {
+		token, err = h.resets.Issue(username)
+	}
+	if err == nil {
+		body := "Use the following token to reset the password of " + username + ":\n\n" + token
+		if h.resetURL != "" {
+			body = "Open the following link to reset the password of " + username + ":\n\n" +
+				h.resetURL + "?token=" + url.QueryEscape(token)
+		}
+		body += "\n\nIf you did not ask for it, you can ignore this message."
+		err = h.notifier.Notify(h.ctx, notify.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body:    body,
+		})
+	}
+	if err != nil {
+		log.Printf("While sending password reset token to %s: %s", username, err.Error())
+	}
+}
+
+// ResetPasswordHandler sets a new password using a token sent by
+// ForgotPasswordHandler. The token can be used once.
+func (h *PasswordHandler) ResetPasswordHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ResetPasswordHandler")
+	defer sp.Finish()
+	var input ResetPasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// the token is used up only once the new password has been accepted
+	username, err := h.resets.Lookup(input.Token)
+	if err == nil {
+		_, err = h.users.GetUser(c.Request.Context(), username)
+	}
+	if errors.Is(err, store.ErrInvalidResetToken) || errors.Is(err, store.ErrUserNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": store.ErrInvalidResetToken.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := password.Validate(input.NewPassword, username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.resets.Consume(input.Token); errors.Is(err, store.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.setPassword(c.Request.Context(), sp, username, input.NewPassword, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.throttle.Reset(username); err != nil {
+		log.Printf("While resetting failed sign-ins of %s: %s", username, err.Error())
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// setPassword hashes and stores a new password, invalidates an outstanding
+// reset token and signs the user out of all token-based sign-ins and all
+// sessions except keepSession.
+func (h *PasswordHandler) setPassword(ctx context.Context, sp opentracing.Span, username, plain, keepSession string) error {
+	sp_hash := NewSubSpan(sp, "password.Hash()")
+	hashed, err := password.Hash(plain)
+	sp_hash.Finish()
+	if err != nil {
+		return err
+	}
+	sp_update := NewSubSpan(sp, "Store.UpdatePassword()")
+	err = h.users.UpdatePassword(ctx, username, hashed)
+	sp_update.Finish()
+	if err != nil {
+		return err
+	}
+	if err := h.resets.Revoke(username); err != nil {
+		return err
+	}
+	if err := h.sessions.RevokeUser(username, keepSession); err != nil {
+		return err
+	}
+	return h.refreshTokens.RevokeUser(username)
+}
@@ -0,0 +1,86 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var resetTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// newResetTestAPI starts the API delivering messages to a file and returns
+// a function waiting for the reset token of the nth message.
+func newResetTestAPI(t *testing.T) (*testAPI, func(n int) string) {
+	outbox := filepath.Join(t.TempDir(), "outbox")
+	api := newTestAPI(t, func(cfg *config.Config, url string) {
+		cfg.Notify.Backend = "file"
+		cfg.Notify.File = outbox
+		cfg.Notify.ResetURL = "https://recipes.example.com/reset"
+	})
+	token := func(n int) string {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			data, _ := ioutil.ReadFile(outbox)
+			if matches := resetTokenPattern.FindAllStringSubmatch(string(data), -1); len(matches) >= n {
+				return matches[n-1][1]
+			}
+		}
+		t.Fatalf("no reset token %d has been sent", n)
+		return ""
+	}
+	return api, token
+}
+
+func TestPasswordReset(t *testing.T) {
+	api, token := newResetTestAPI(t)
+	api.signUp(t, "alice", models.RoleAuthor)
+	c := api.client(t)
+	c.expect(http.StatusAccepted, http.MethodPost, "/password/forgot", gin.H{"username": "alice"})
+	reset := gin.H{"token": token(1), "newPassword": "Fresh secret 2 for me"}
+	c.expect(http.StatusOK, http.MethodPost, "/password/reset", reset)
+	c.expect(http.StatusBadRequest, http.MethodPost, "/password/reset", reset)
+	c.expect(http.StatusOK, http.MethodPost, "/signin", gin.H{"username": "alice", "password": "Fresh secret 2 for me"})
+}
+
+func TestPasswordChangeRevokesResetToken(t *testing.T) {
+	api, token := newResetTestAPI(t)
+	api.signUp(t, "alice", models.RoleAuthor)
+	api.client(t).expect(http.StatusAccepted, http.MethodPost, "/password/forgot", gin.H{"username": "alice"})
+	stale := token(1)
+	api.signIn(t, "alice").expect(http.StatusOK, http.MethodPut, "/users/me/password", gin.H{
+		"currentPassword": testPassword,
+		"newPassword":     "Fresh secret 2 for me",
+	})
+	api.client(t).expect(http.StatusBadRequest, http.MethodPost, "/password/reset", gin.H{
+		"token":       stale,
+		"newPassword": "Attacker password 3",
+	})
+}
+
+func TestForgotPasswordRateLimit(t *testing.T) {
+	api, _ := newResetTestAPI(t)
+	c := api.client(t)
+	c.expect(http.StatusAccepted, http.MethodPost, "/password/forgot", gin.H{"username": "alice"})
+	res := c.expect(http.StatusTooManyRequests, http.MethodPost, "/password/forgot", gin.H{"username": "alice"})
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err != nil || seconds < 1 || seconds > 60 {
+		t.Errorf("Retry-After = %q, want at most a minute", res.Header.Get("Retry-After"))
+	}
+	api.redis.FastForward(time.Minute)
+	c.expect(http.StatusAccepted, http.MethodPost, "/password/forgot", gin.H{"username": "alice"})
+
+	// all requests count against the IP, including rejected ones and those
+	// for usernames that do not exist
+	for i := 4; i <= 10; i++ {
+		c.expect(http.StatusAccepted, http.MethodPost, "/password/forgot", gin.H{"username": "user" + strconv.Itoa(i)})
+	}
+	c.expect(http.StatusTooManyRequests, http.MethodPost, "/password/forgot", gin.H{"username": "user11"})
+}
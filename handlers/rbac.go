@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/store"
+	"net/http"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// roleKey is the gin.Context key under which RequirePermission stores the role.
+const roleKey = "role"
+
+// RequirePermission aborts the request unless the user authenticated by
+// AuthMiddleware has a role granting p and, for requests authenticated with
+// an API key, the key has p in its scopes. It must be used after AuthMiddleware.
+func (h *AuthHandler) RequirePermission(p models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := h.users.GetUser(c.Request.Context(), CurrentUser(c))
+		if errors.Is(err, store.ErrUserNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Unknown user"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if !user.Role.Can(p) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			c.Abort()
+			return
+		}
+		if key, ok := currentAPIKey(c); ok && !key.HasScope(p) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + string(p)})
+			c.Abort()
+			return
+		}
+		c.Set(roleKey, user.Role)
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the role loaded by RequirePermission grants
+// p and, for requests authenticated with an API key, the key has p in its scopes.
+func HasPermission(c *gin.Context, p models.Permission) bool {
+	role, _ := c.Get(roleKey)
+	r, ok := role.(models.Role)
+	if key, isKey := currentAPIKey(c); isKey && !key.HasScope(p) {
+		return false
+	}
+	return ok && r.Can(p)
+}
+
+type RoleInput struct {
+	Role models.Role `json:"role" binding:"required"`
+}
+
+// UpdateRoleHandler changes the role of the user given in the path.
+func (h *AuthHandler) UpdateRoleHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "UpdateRoleHandler")
+	defer sp.Finish()
+	var input RoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !input.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of admin, editor, author or reader"})
+		return
+	}
+	sp_update := NewSubSpan(sp, "Store.UpdateRole()")
+	err := h.users.UpdateRole(c.Request.Context(), c.Param("username"), input.Role)
+	sp_update.Finish()
+	if errors.Is(err, store.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role has been updated"})
+}
@@ -0,0 +1,123 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"local/gin/gin-recipes-api/handlers"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createRecipe creates a recipe named name as the signed-in user of c and
+// returns its path.
+func createRecipe(t *testing.T, c *client, name string) string {
+	t.Helper()
+	var recipe models.Recipe
+	c.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": name, "createdBy": "someone-else"}).decode(t, &recipe)
+	if recipe.CreatedBy == "someone-else" {
+		t.Fatalf("POST /recipes kept the client's createdBy")
+	}
+	return "/recipes/" + recipe.ID.Hex()
+}
+
+func TestRecipeOwnership(t *testing.T) {
+	api := newTestAPI(t, nil)
+	for username, role := range map[string]models.Role{
+		"alice": models.RoleAuthor,
+		"bob":   models.RoleAuthor,
+		"erin":  models.RoleEditor,
+		"root":  models.RoleAdmin,
+		"rita":  models.RoleReader,
+	} {
+		api.signUp(t, username, role)
+	}
+	alice, bob, erin, root, rita := api.signIn(t, "alice"), api.signIn(t, "bob"), api.signIn(t, "erin"), api.signIn(t, "root"), api.signIn(t, "rita")
+	update := gin.H{"name": "Stew"}
+
+	rita.expect(http.StatusForbidden, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	soup := createRecipe(t, alice, "Soup")
+	var recipe models.Recipe
+	rita.expect(http.StatusOK, http.MethodGet, soup, nil).decode(t, &recipe)
+	if recipe.CreatedBy != "alice" {
+		t.Errorf("createdBy = %q, want alice", recipe.CreatedBy)
+	}
+
+	// authors may only modify their own recipes
+	bob.expect(http.StatusForbidden, http.MethodPut, soup, update)
+	bob.expect(http.StatusForbidden, http.MethodDelete, soup, nil)
+	rita.expect(http.StatusForbidden, http.MethodPut, soup, update)
+	alice.expect(http.StatusOK, http.MethodPut, soup, update)
+	bob.expect(http.StatusNotFound, http.MethodPut, "/recipes/"+primitive.NewObjectID().Hex(), update)
+
+	// editors may update, but not delete, any recipe
+	erin.expect(http.StatusOK, http.MethodPut, soup, gin.H{"name": "Goulash"})
+	rita.expect(http.StatusOK, http.MethodGet, soup, nil).decode(t, &recipe)
+	if recipe.CreatedBy != "alice" || recipe.UpdatedBy != "erin" {
+		t.Errorf("createdBy %q, updatedBy %q after the edit, want alice and erin", recipe.CreatedBy, recipe.UpdatedBy)
+	}
+	erin.expect(http.StatusForbidden, http.MethodDelete, soup, nil)
+
+	// admins may delete any recipe, authors their own
+	root.expect(http.StatusOK, http.MethodDelete, soup, nil)
+	pie := createRecipe(t, bob, "Pie")
+	bob.expect(http.StatusOK, http.MethodDelete, pie, nil)
+}
+
+func TestRecipeOwnershipLegacy(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleAuthor)
+	api.signUp(t, "erin", models.RoleEditor)
+	// recipes from before authorship was recorded belong to nobody
+	legacy := models.Recipe{Name: "Soup"}
+	if err := api.recipes.Create(context.Background(), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	path := "/recipes/" + legacy.ID.Hex()
+	api.signIn(t, "alice").expect(http.StatusForbidden, http.MethodPut, path, gin.H{"name": "Stew"})
+	api.signIn(t, "erin").expect(http.StatusOK, http.MethodPut, path, gin.H{"name": "Stew"})
+}
+
+func TestRecipeOwnershipAPIKeyScopes(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleEditor)
+	alice := api.signIn(t, "alice")
+	soup := createRecipe(t, alice, "Soup")
+	var key handlers.APIKeyOutput
+	alice.expect(http.StatusCreated, http.MethodPost, "/users/me/apikeys", gin.H{
+		"name":      "ci",
+		"scopes":    []models.Permission{models.PermCreateRecipe, models.PermUpdateRecipe},
+		"expiresIn": "1h",
+	}).decode(t, &key)
+	alice.expect(http.StatusForbidden, http.MethodPost, "/users/me/apikeys", gin.H{
+		"name":      "too much",
+		"scopes":    []models.Permission{models.PermManageUsers},
+		"expiresIn": "1h",
+	})
+
+	machine := api.client(t)
+	machine.header.Set("X-API-Key", key.Key)
+	machine.expect(http.StatusOK, http.MethodPut, soup, gin.H{"name": "Stew"})
+	machine.expect(http.StatusForbidden, http.MethodDelete, soup, nil)
+	// the key cannot use the editor's right to update any recipe
+	api.signUp(t, "bob", models.RoleAuthor)
+	pie := createRecipe(t, api.signIn(t, "bob"), "Pie")
+	machine.expect(http.StatusForbidden, http.MethodPut, pie, gin.H{"name": "Tart"})
+	alice.expect(http.StatusOK, http.MethodPut, pie, gin.H{"name": "Tart"})
+}
+
+func TestUpdateRole(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleAuthor)
+	api.signUp(t, "root", models.RoleAdmin)
+	alice, root := api.signIn(t, "alice"), api.signIn(t, "root")
+
+	alice.expect(http.StatusForbidden, http.MethodPut, "/users/alice/role", gin.H{"role": models.RoleAdmin})
+	root.expect(http.StatusBadRequest, http.MethodPut, "/users/alice/role", gin.H{"role": "owner"})
+	root.expect(http.StatusNotFound, http.MethodPut, "/users/nobody/role", gin.H{"role": models.RoleEditor})
+	root.expect(http.StatusOK, http.MethodPut, "/users/alice/role", gin.H{"role": models.RoleReader})
+	alice.expect(http.StatusForbidden, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+}
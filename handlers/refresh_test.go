@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/handlers"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefreshRotation(t *testing.T) {
+	api := newTestAPI(t, authMode(config.AuthModeJWT))
+	api.signUp(t, "alice", models.RoleAuthor)
+	first := api.signInJWT(t, "alice")
+
+	var second handlers.JWTOutput
+	api.bearer(t, first.RefreshToken).expect(http.StatusOK, http.MethodPost, "/refresh", nil).decode(t, &second)
+	if second.Token == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh returned %+v, want a new access and refresh token", second)
+	}
+	api.bearer(t, second.Token).expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	api.bearer(t, second.RefreshToken).expect(http.StatusOK, http.MethodPost, "/refresh", nil)
+
+	api.client(t).expect(http.StatusUnauthorized, http.MethodPost, "/refresh", nil)
+	api.bearer(t, "unknown").expect(http.StatusUnauthorized, http.MethodPost, "/refresh", nil)
+}
+
+func TestRefreshReuseRevokesSignIn(t *testing.T) {
+	api := newTestAPI(t, authMode(config.AuthModeJWT))
+	api.signUp(t, "alice", models.RoleAuthor)
+	stolen := api.signInJWT(t, "alice")
+	other := api.signInJWT(t, "alice")
+
+	var rotated handlers.JWTOutput
+	api.bearer(t, stolen.RefreshToken).expect(http.StatusOK, http.MethodPost, "/refresh", nil).decode(t, &rotated)
+	api.bearer(t, stolen.RefreshToken).expect(http.StatusUnauthorized, http.MethodPost, "/refresh", nil)
+
+	// reuse revokes every token of the sign-in, but no other sign-in
+	recipe := gin.H{"name": "Soup"}
+	api.bearer(t, rotated.Token).expect(http.StatusUnauthorized, http.MethodPost, "/recipes", recipe)
+	api.bearer(t, rotated.RefreshToken).expect(http.StatusUnauthorized, http.MethodPost, "/refresh", nil)
+	api.bearer(t, other.Token).expect(http.StatusOK, http.MethodPost, "/recipes", recipe)
+	api.bearer(t, other.RefreshToken).expect(http.StatusOK, http.MethodPost, "/refresh", nil)
+}
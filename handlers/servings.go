@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"local/gin/gin-recipes-api/ingredient"
+	"local/gin/gin-recipes-api/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// maxServings is the largest number of servings a recipe can be scaled to.
+const maxServings = 1000
+
+// parseServings returns the servings query parameter, or 0 if it is absent.
+func parseServings(c *gin.Context) (int, error) {
+	value := c.Query("servings")
+	if value == "" {
+		return 0, nil
+	}
+	servings, err := strconv.Atoi(value)
+	if err != nil || servings < 1 || servings > maxServings {
+		return 0, errors.Errorf("servings must be a number between 1 and %d", maxServings)
+	}
+	return servings, nil
+}
+
+// scaleRecipe returns the recipe with its ingredients rescaled to the given
+// number of servings.
+func scaleRecipe(recipe models.Recipe, servings int) (models.Recipe, error) {
+	if servings == 0 || servings == recipe.Servings {
+		return recipe, nil
+	}
+	if recipe.Servings == 0 {
+		return recipe, errors.New("Recipe does not state its servings and cannot be scaled")
+	}
+	factor := ingredient.NewRational(int64(servings), int64(recipe.Servings))
+	ingredients := make([]ingredient.Ingredient, 0, len(recipe.Ingredients))
+	for _, ing := range recipe.Ingredients {
+		ingredients = append(ingredients, ing.Scale(factor))
+	}
+	recipe.Ingredients = ingredients
+	recipe.Servings = servings
+	return recipe, nil
+}
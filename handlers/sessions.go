@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"local/gin/gin-recipes-api/store"
+	"net/http"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// ListSessionsHandler returns the active sessions of the current user.
+func (h *AuthHandler) ListSessionsHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ListSessionsHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	h.listSessions(c, CurrentUser(c))
+}
+
+// DeleteSessionHandler revokes a session of the current user.
+func (h *AuthHandler) DeleteSessionHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "DeleteSessionHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	id := c.Param("id")
+	sp_revoke := NewSubSpan(sp, "Sessions.Revoke()")
+	err := h.sessions.Revoke(CurrentUser(c), id)
+	sp_revoke.Finish()
+	if errors.Is(err, store.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if id == currentSessionID(c) {
+		session := sessions.Default(c)
+		session.Clear()
+		session.Save()
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session has been revoked"})
+}
+
+// ListUserSessionsHandler returns the active sessions of the user given in the path.
+func (h *AuthHandler) ListUserSessionsHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ListUserSessionsHandler")
+	defer sp.Finish()
+	h.listSessions(c, c.Param("username"))
+}
+
+// RevokeUserSessionsHandler signs the user given in the path out everywhere:
+// all sessions and refresh tokens are revoked.
+func (h *AuthHandler) RevokeUserSessionsHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "RevokeUserSessionsHandler")
+	defer sp.Finish()
+	username := c.Param("username")
+	sp_revoke := NewSubSpan(sp, "Sessions.RevokeUser()")
+	err := h.sessions.RevokeUser(username, "")
+	if err == nil {
+		err = h.refreshTokens.RevokeUser(username)
+	}
+	sp_revoke.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions of " + username + " have been revoked"})
+}
+
+func (h *AuthHandler) listSessions(c *gin.Context, username string) {
+	list, err := h.sessions.List(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	current := currentSessionID(c)
+	for i := range list {
+		list[i].Current = list[i].ID == current
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// currentSessionID returns the ID of the session the request was
+// authenticated with, or "" for other authentication methods.
+func currentSessionID(c *gin.Context) string {
+	return c.GetString(sessionIDKey)
+}
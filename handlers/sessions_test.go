@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"local/gin/gin-recipes-api/config"
+	"local/gin/gin-recipes-api/handlers"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listSessions returns the sessions of the signed-in user of c.
+func listSessions(t *testing.T, c *client) []models.Session {
+	t.Helper()
+	var list []models.Session
+	c.expect(http.StatusOK, http.MethodGet, "/users/me/sessions", nil).decode(t, &list)
+	return list
+}
+
+func TestSessions(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleAuthor)
+	api.signUp(t, "bob", models.RoleAuthor)
+	laptop := api.signIn(t, "alice")
+	phone := api.signIn(t, "alice")
+	bob := api.signIn(t, "bob")
+
+	list := listSessions(t, laptop)
+	if len(list) != 2 {
+		t.Fatalf("GET /users/me/sessions returned %d sessions, want 2", len(list))
+	}
+	var current, other string
+	for _, session := range list {
+		if session.Current {
+			current = session.ID
+		} else {
+			other = session.ID
+		}
+	}
+	if current == "" || other == "" {
+		t.Fatalf("GET /users/me/sessions = %+v, want exactly one current session", list)
+	}
+
+	bob.expect(http.StatusNotFound, http.MethodDelete, "/users/me/sessions/"+other, nil)
+	laptop.expect(http.StatusOK, http.MethodDelete, "/users/me/sessions/"+other, nil)
+	phone.expect(http.StatusForbidden, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	laptop.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+
+	// revoking the current session signs out
+	laptop.expect(http.StatusOK, http.MethodDelete, "/users/me/sessions/"+current, nil)
+	laptop.expect(http.StatusForbidden, http.MethodGet, "/users/me/sessions", nil)
+	bob.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Stew"})
+}
+
+func TestSessionsRejectAPIKeys(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.signUp(t, "alice", models.RoleAuthor)
+	c := api.signIn(t, "alice")
+	var key handlers.APIKeyOutput
+	c.expect(http.StatusCreated, http.MethodPost, "/users/me/apikeys", gin.H{
+		"name":      "ci",
+		"scopes":    []models.Permission{models.PermCreateRecipe},
+		"expiresIn": "1h",
+	}).decode(t, &key)
+	session := listSessions(t, c)[0].ID
+
+	machine := api.client(t)
+	machine.header.Set("X-API-Key", key.Key)
+	machine.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	machine.expect(http.StatusForbidden, http.MethodGet, "/users/me/sessions", nil)
+	machine.expect(http.StatusForbidden, http.MethodDelete, "/users/me/sessions/"+session, nil)
+	c.expect(http.StatusOK, http.MethodGet, "/users/me/sessions", nil)
+}
+
+func TestAdminRevokesUserSessions(t *testing.T) {
+	api := newTestAPI(t, authMode(config.AuthModeBoth))
+	api.signUp(t, "root", models.RoleAdmin)
+	api.signUp(t, "alice", models.RoleAuthor)
+	admin := api.signIn(t, "root")
+	alice := api.signIn(t, "alice")
+	tokens := api.signInJWT(t, "alice")
+
+	alice.expect(http.StatusForbidden, http.MethodGet, "/users/root/sessions", nil)
+	alice.expect(http.StatusForbidden, http.MethodDelete, "/users/root/sessions", nil)
+	var list []models.Session
+	admin.expect(http.StatusOK, http.MethodGet, "/users/alice/sessions", nil).decode(t, &list)
+	if len(list) != 2 {
+		t.Errorf("GET /users/alice/sessions returned %d sessions, want 2", len(list))
+	}
+
+	admin.expect(http.StatusOK, http.MethodDelete, "/users/alice/sessions", nil)
+	alice.expect(http.StatusForbidden, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	api.bearer(t, tokens.Token).expect(http.StatusUnauthorized, http.MethodPost, "/recipes", gin.H{"name": "Soup"})
+	api.bearer(t, tokens.RefreshToken).expect(http.StatusUnauthorized, http.MethodPost, "/refresh", nil)
+	admin.expect(http.StatusOK, http.MethodGet, "/users/alice/sessions", nil).decode(t, &list)
+	if len(list) != 0 {
+		t.Errorf("GET /users/alice/sessions after revoking = %+v, want none", list)
+	}
+}
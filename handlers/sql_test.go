@@ -0,0 +1,74 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"local/gin/gin-recipes-api/handlers"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSQLBackend(t *testing.T) {
+	dir := t.TempDir()
+	api := newTestAPI(t, sqlBackend(dir))
+	api.signUp(t, "alice", models.RoleAuthor)
+	api.signUp(t, "root", models.RoleAdmin)
+	api.client(t).expect(http.StatusConflict, http.MethodPost, "/signup", gin.H{
+		"username": "alice",
+		"password": testPassword,
+		"email":    "other@example.com",
+	})
+
+	alice := api.signIn(t, "alice")
+	var recipe models.Recipe
+	alice.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{
+		"name":         "Tomato soup",
+		"tags":         []string{"soup"},
+		"ingredients":  []string{"1 1/2 cups tomatoes, diced"},
+		"instructions": []string{"Simmer."},
+	}).decode(t, &recipe)
+	alice.expect(http.StatusOK, http.MethodPut, "/recipes/"+recipe.ID.Hex(), gin.H{
+		"name":         "Creamy tomato soup",
+		"tags":         []string{"soup"},
+		"ingredients":  []string{"1 cup cream"},
+		"instructions": []string{"Stir in the cream."},
+	})
+
+	var key handlers.APIKeyOutput
+	alice.expect(http.StatusCreated, http.MethodPost, "/users/me/apikeys", gin.H{
+		"name":      "ci",
+		"scopes":    []models.Permission{models.PermCreateRecipe},
+		"expiresIn": "1h",
+	}).decode(t, &key)
+	machine := api.client(t)
+	machine.header.Set("X-API-Key", key.Key)
+	machine.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Stew"})
+
+	api.signIn(t, "root").expect(http.StatusOK, http.MethodPut, "/users/alice/role", gin.H{"role": models.RoleEditor})
+	user, err := api.users.GetUser(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleEditor {
+		t.Errorf("role in the database = %q, want editor", user.Role)
+	}
+
+	// everything is in the database, not in the App
+	restarted := newTestAPI(t, sqlBackend(dir))
+	var recipes []models.Recipe
+	restarted.client(t).expect(http.StatusOK, http.MethodGet, "/recipes", nil).decode(t, &recipes)
+	if len(recipes) != 2 {
+		t.Fatalf("GET /recipes after restart returned %d recipes, want 2", len(recipes))
+	}
+	var got models.Recipe
+	restarted.client(t).expect(http.StatusOK, http.MethodGet, "/recipes/"+recipe.ID.Hex(), nil).decode(t, &got)
+	if got.Name != "Creamy tomato soup" || got.CreatedBy != "alice" || len(got.Ingredients) != 1 || got.Ingredients[0].Item != "cream" {
+		t.Errorf("GET /recipes/%s after restart = %+v", recipe.ID.Hex(), got)
+	}
+	restarted.signIn(t, "alice")
+	machine.api = restarted
+	machine.expect(http.StatusOK, http.MethodPost, "/recipes", gin.H{"name": "Chili"})
+}
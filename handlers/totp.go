@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/store"
+	"local/gin/gin-recipes-api/totp"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/opengintracing"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// recoveryCodeCount is the number of recovery codes issued on enrollment.
+const recoveryCodeCount = 10
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCodeInput struct {
+	// Code is a code of the authenticator app or a recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+type TOTPSignInInput struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+// EnrollTOTPHandler generates a new TOTP secret for the current user. It
+// takes effect once confirmed through ConfirmTOTPHandler.
+func (h *AuthHandler) EnrollTOTPHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "EnrollTOTPHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	user, ok := h.currentUserRecord(c)
+	if !ok {
+		return
+	}
+	if user.TOTP.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sp_update := NewSubSpan(sp, "Store.UpdateTOTP()")
+	err = h.users.UpdateTOTP(c.Request.Context(), user.Username, models.TOTP{Secret: secret})
+	sp_update.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(h.cfg.TOTPIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmTOTPHandler enables two-factor authentication once the user proves
+// to have set up the authenticator with a valid code. The response holds the
+// recovery codes, which are not shown again.
+func (h *AuthHandler) ConfirmTOTPHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "ConfirmTOTPHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	var input TOTPCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := h.currentUserRecord(c)
+	if !ok {
+		return
+	}
+	if user.TOTP.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTP.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication has not been enrolled"})
+		return
+	}
+	counter, valid := totp.Validate(user.TOTP.Secret, input.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	settings := models.TOTP{
+		Secret:      user.TOTP.Secret,
+		Enabled:     true,
+		LastCounter: counter,
+	}
+	for _, code := range codes {
+		settings.RecoveryCodes = append(settings.RecoveryCodes, totp.HashRecoveryCode(code))
+	}
+	sp_update := NewSubSpan(sp, "Store.UpdateTOTP()")
+	err = h.users.UpdateTOTP(c.Request.Context(), user.Username, settings)
+	sp_update.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// DisableTOTPHandler turns two-factor authentication off; it requires a
+// current code or a recovery code.
+func (h *AuthHandler) DisableTOTPHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "DisableTOTPHandler")
+	defer sp.Finish()
+	if rejectAPIKey(c) {
+		return
+	}
+	var input TOTPCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := h.currentUserRecord(c)
+	if !ok {
+		return
+	}
+	if !user.TOTP.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !verifySecondFactor(&user.TOTP, input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+	sp_update := NewSubSpan(sp, "Store.UpdateTOTP()")
+	err := h.users.UpdateTOTP(c.Request.Context(), user.Username, models.TOTP{})
+	sp_update.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication has been disabled"})
+}
+
+// TOTPSignInHandler is the second step of the sign-in of users with two-factor
+// authentication: it answers the challenge returned by SignInHandler with a
+// code and then signs the user in like SignInHandler does for other users.
+func (h *AuthHandler) TOTPSignInHandler(c *gin.Context) {
+	span := opengintracing.MustGetSpan(c)
+	sp := NewSubSpan(span, "TOTPSignInHandler")
+	defer sp.Finish()
+	var input TOTPSignInInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sp_challenge := NewSubSpan(sp, "Challenges.Attempt()")
+	username, err := h.challenges.Attempt(input.Challenge)
+	sp_challenge.Finish()
+	if errors.Is(err, store.ErrInvalidChallenge) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if h.throttled(c, username) {
+		return
+	}
+	sp_user := NewSubSpan(sp, "Store.GetUser()")
+	user, err := h.users.GetUser(c.Request.Context(), username)
+	sp_user.Finish()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	previous := user.TOTP
+	if !verifySecondFactor(&user.TOTP, input.Code) {
+		h.signInFailed(c, username, reasonWrongCode)
+		return
+	}
+	// the settings are only stored if no concurrent sign-in consumed the
+	// same code in the meantime
+	sp_update := NewSubSpan(sp, "Store.SwapTOTP()")
+	err = h.users.SwapTOTP(c.Request.Context(), username, previous, user.TOTP)
+	sp_update.Finish()
+	if errors.Is(err, store.ErrTOTPChanged) {
+		h.signInFailed(c, username, reasonWrongCode)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.challenges.Finish(input.Challenge); err != nil {
+		log.Printf("While finishing sign-in challenge of %s: %s", username, err.Error())
+	}
+	if err := h.throttle.Reset(username); err != nil {
+		log.Printf("While resetting failed sign-ins of %s: %s", username, err.Error())
+	}
+	h.signIn(c, sp, username)
+}
+
+// verifySecondFactor checks code against the TOTP secret, rejecting codes
+// that were already used, or against the unused recovery codes. On success
+// it updates settings, which the caller must store.
+func verifySecondFactor(settings *models.TOTP, code string) bool {
+	if counter, ok := totp.Validate(settings.Secret, code, time.Now()); ok {
+		if counter <= settings.LastCounter {
+			return false
+		}
+		settings.LastCounter = counter
+		return true
+	}
+	hash := totp.HashRecoveryCode(code)
+	for i, recoveryCode := range settings.RecoveryCodes {
+		if recoveryCode == hash {
+			remaining := make([]string, 0, len(settings.RecoveryCodes)-1)
+			remaining = append(remaining, settings.RecoveryCodes[:i]...)
+			settings.RecoveryCodes = append(remaining, settings.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// currentUserRecord loads the user authenticated by AuthMiddleware and
+// responds with an error if that fails.
+func (h *AuthHandler) currentUserRecord(c *gin.Context) (models.User, bool) {
+	user, err := h.users.GetUser(c.Request.Context(), CurrentUser(c))
+	if errors.Is(err, store.ErrUserNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unknown user"})
+		return user, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return user, false
+	}
+	return user, true
+}
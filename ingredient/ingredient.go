@@ -0,0 +1,290 @@
+// Package ingredient parses the free-text ingredient lines of recipes,
+// such as "1 1/2 cups flour, sifted" or "6 to 7-ounce salmon fillets", into
+// quantity, unit, item and preparation note, and compares them with the
+// ingredients on hand.
+package ingredient
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Ingredient is a parsed ingredient line.
+type Ingredient struct {
+	// Quantity is nil for lines without an amount, like "salt".
+	Quantity *Rational `json:"quantity,omitempty" bson:"quantity,omitempty"`
+	// QuantityMax is the upper end of ranges like "2 to 3", nil otherwise.
+	QuantityMax *Rational `json:"quantityMax,omitempty" bson:"quantityMax,omitempty"`
+	// Unit is the canonical unit, e.g. "tbsp" for "Tablespoons".
+	Unit string `json:"unit,omitempty" bson:"unit,omitempty"`
+	// Item is what to use, e.g. "red onion".
+	Item string `json:"item" bson:"item"`
+	// Note holds preparation hints and remarks like "finely chopped".
+	Note string `json:"note,omitempty" bson:"note,omitempty"`
+	// Original is the line as written, without surrounding whitespace.
+	Original string `json:"original" bson:"original"`
+}
+
+const vulgar = `½⅓⅔¼¾⅕⅖⅗⅘⅙⅚⅐⅛⅜⅝⅞⅑⅒`
+
+// amount matches a single quantity: "1 1/2", "1 ½", "3/4", "1½", "½", "1.5" or "2".
+const amount = `(?:\d+\s+\d+[/⁄]\d+|\d+\s+[` + vulgar + `]|\d+[/⁄]\d+|\d*[` + vulgar + `]|\d+(?:\.\d+)?)`
+
+var (
+	// quantityPrefix matches a quantity or range at the start of a line. A
+	// hyphen may join the quantity to its unit as in "7-ounce".
+	quantityPrefix = regexp.MustCompile(`^(` + amount + `)(?:\s*(?:-|–|—|\bto\b)\s*(` + amount + `))?(?:\s*[-–]\s*|\s+|$)`)
+	// packageSize matches the size of a package following the quantity, as
+	// in "2 14oz cans" or "1 28-ounce can".
+	packageSize  = regexp.MustCompile(`^(` + amount + `\s*-?\s*([A-Za-z]+)\.?)\s+`)
+	leadingNote  = regexp.MustCompile(`^\(([^)]*)\)\s*`)
+	leadingSize  = regexp.MustCompile(`(?i)^(small|medium|large|extra-large)\s+`)
+	twoWordUnit  = regexp.MustCompile(`(?i)^(fl\.?\s*oz|fluid\s+ounces?)\.?(?:\s+|$)`)
+	leadingWord  = regexp.MustCompile(`^([A-Za-z]+)\.?(?:\s+|$)`)
+	leadingOf    = regexp.MustCompile(`(?i)^of\s+`)
+	parenthetics = regexp.MustCompile(`\s*\(([^)]*)\)`)
+	markup       = regexp.MustCompile(`<[^>]*>`)
+	// purpose matches what an ingredient is used for or how much to take
+	// when the line gives no quantity, e.g. "for serving" or "to taste".
+	purpose = regexp.MustCompile(`(?i)(?:^|\s+)((?:to taste|as needed|for\s).*)$`)
+)
+
+// units maps the spellings of units to their canonical names.
+var units = map[string]string{
+	"tsp": "tsp", "teaspoon": "tsp",
+	"tbsp": "tbsp", "tbs": "tbsp", "tbl": "tbsp", "tb": "tbsp", "tablespoon": "tbsp",
+	"c": "cup", "cup": "cup",
+	"oz": "oz", "ounce": "oz",
+	"lb": "lb", "lbs": "lb", "pound": "lb",
+	"g": "g", "gr": "g", "gram": "g",
+	"kg": "kg", "kilogram": "kg",
+	"ml": "ml", "milliliter": "ml", "millilitre": "ml",
+	"l": "l", "liter": "l", "litre": "l",
+	"pt": "pint", "pint": "pint",
+	"qt": "quart", "quart": "quart",
+	"gal": "gallon", "gallon": "gallon",
+	"can": "can", "jar": "jar", "package": "package", "pkg": "package", "packet": "packet",
+	"envelope": "envelope", "bag": "bag", "box": "box", "bottle": "bottle",
+	"bunch": "bunch", "head": "head", "clove": "clove", "sprig": "sprig", "stalk": "stalk",
+	"pinch": "pinch", "dash": "dash", "handful": "handful", "slice": "slice", "stick": "stick",
+	"piece": "piece", "inch": "inch", "drop": "drop", "scoop": "scoop",
+}
+
+// sizes describe the amount of an ingredient rather than the ingredient.
+var sizes = set("small", "medium", "large", "extra-large", "heaping", "level", "scant", "generous", "whole")
+
+var stopWords = set("a", "an", "and", "of", "or", "the", "to", "optional", "about", "approximately")
+
+// Parse parses an ingredient line. Text it cannot make sense of ends up in
+// Item, so nothing is lost.
+func Parse(line string) Ingredient {
+	ing := Ingredient{Original: strings.TrimSpace(line)}
+	rest := ing.Original
+	notes := make([]string, 0)
+	if m := quantityPrefix.FindStringSubmatch(rest); m != nil {
+		if quantity, err := ParseRational(m[1]); err == nil {
+			ing.Quantity = &quantity
+			if max, err := ParseRational(m[2]); m[2] != "" && err == nil {
+				ing.QuantityMax = &max
+			}
+			rest = rest[len(m[0]):]
+		}
+	}
+	if ing.Quantity != nil {
+		if m := leadingNote.FindStringSubmatch(rest); m != nil {
+			notes = append(notes, m[1])
+			rest = rest[len(m[0]):]
+		} else if m := packageSize.FindStringSubmatch(rest); m != nil && lookupUnit(m[2]) != "" {
+			notes = append(notes, m[1])
+			rest = rest[len(m[0]):]
+		}
+	}
+	if m := leadingSize.FindStringSubmatch(rest); m != nil && ing.Quantity != nil {
+		// "1 large head cauliflower" is a large head, not large cauliflower.
+		if unit, _ := leadingUnit(rest[len(m[0]):]); unit != "" {
+			notes = append(notes, m[1])
+			rest = rest[len(m[0]):]
+		}
+	}
+	if unit, n := leadingUnit(rest); unit != "" && (ing.Quantity != nil || leadingOf.MatchString(rest[n:])) {
+		// "2 cloves" names the item rather than a unit of it.
+		if remainder := leadingOf.ReplaceAllString(rest[n:], ""); strings.TrimSpace(remainder) != "" {
+			ing.Unit = unit
+			rest = remainder
+		}
+	}
+	for _, m := range parenthetics.FindAllStringSubmatch(rest, -1) {
+		notes = append(notes, m[1])
+	}
+	rest = parenthetics.ReplaceAllString(rest, "")
+	if i := strings.Index(rest, ","); i >= 0 {
+		notes = append(notes, rest[i+1:])
+		rest = rest[:i]
+	}
+	if m := purpose.FindStringSubmatchIndex(rest); m != nil {
+		notes = append(notes, rest[m[2]:m[3]])
+		rest = rest[:m[0]]
+	}
+	ing.Item = strings.TrimSpace(markup.ReplaceAllString(rest, ""))
+	ing.Note = joinNotes(notes)
+	return ing
+}
+
+// leadingUnit returns the canonical unit at the start of s and the length
+// of its spelling, or an empty unit.
+func leadingUnit(s string) (string, int) {
+	if m := twoWordUnit.FindString(s); m != "" {
+		return "fl oz", len(m)
+	}
+	if m := leadingWord.FindStringSubmatch(s); m != nil {
+		if unit := lookupUnit(m[1]); unit != "" {
+			return unit, len(m[0])
+		}
+	}
+	return "", 0
+}
+
+func lookupUnit(word string) string {
+	word = strings.ToLower(word)
+	if unit, ok := units[word]; ok {
+		return unit
+	}
+	return units[Singular(word)]
+}
+
+func joinNotes(notes []string) string {
+	kept := make([]string, 0, len(notes))
+	for _, note := range notes {
+		if note = strings.TrimSpace(note); note != "" {
+			kept = append(kept, note)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+// String formats the ingredient from its parsed fields, e.g.
+// "1 1/2 cup flour, sifted".
+func (i Ingredient) String() string {
+	parts := make([]string, 0, 3)
+	if i.Quantity != nil {
+		quantity := i.Quantity.Mixed()
+		if i.QuantityMax != nil {
+			quantity += " to " + i.QuantityMax.Mixed()
+		}
+		parts = append(parts, quantity)
+	}
+	if i.Unit != "" {
+		parts = append(parts, i.Unit)
+	}
+	if i.Item != "" {
+		parts = append(parts, i.Item)
+	}
+	s := strings.Join(parts, " ")
+	if i.Note != "" {
+		s += ", " + i.Note
+	}
+	return s
+}
+
+// Text returns the original line or, for ingredients given by their
+// fields only, the formatted fields.
+func (i Ingredient) Text() string {
+	if i.Original != "" {
+		return i.Original
+	}
+	return i.String()
+}
+
+// UnmarshalJSON accepts a plain ingredient line, which is parsed, as well
+// as an object. Objects without an original line get one formatted from
+// their fields.
+func (i *Ingredient) UnmarshalJSON(data []byte) error {
+	var line string
+	if err := json.Unmarshal(data, &line); err == nil {
+		*i = Parse(line)
+		return nil
+	}
+	type plain Ingredient
+	if err := json.Unmarshal(data, (*plain)(i)); err != nil {
+		return err
+	}
+	i.Original = strings.TrimSpace(i.Original)
+	if i.Original == "" {
+		i.Original = i.String()
+	}
+	return nil
+}
+
+// UnmarshalBSONValue accepts a plain ingredient line as well as a
+// document, so that recipes stored before ingredients were parsed can
+// still be read.
+func (i *Ingredient) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.String {
+		line, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return errors.New("invalid BSON string")
+		}
+		*i = Parse(line)
+		return nil
+	}
+	type plain Ingredient
+	return bson.Unmarshal(data, (*plain)(i))
+}
+
+// Normalize returns the item of the ingredient in lower case and singular,
+// without sizes and filler words: "2 large carrots, peeled" becomes
+// "carrot". It returns an empty string for lines without an ingredient,
+// like section separators.
+func Normalize(ing Ingredient) string {
+	return strings.Join(Words(ing), " ")
+}
+
+// Words returns the words of Normalize.
+func Words(ing Ingredient) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(ing.Item), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '\''
+	})
+	words := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		token = strings.Trim(token, "-'")
+		switch {
+		case token == "" || stopWords[token]:
+			continue
+		case len(words) == 0 && sizes[token]:
+			continue
+		}
+		words = append(words, Singular(token))
+	}
+	return words
+}
+
+// Singular reduces English plurals to their singular, so that "tomatoes"
+// matches "tomato" and "berries" matches "berry".
+func Singular(word string) string {
+	word = strings.TrimSuffix(word, "'s")
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 4 && (strings.HasSuffix(word, "oes") || strings.HasSuffix(word, "ches") ||
+		strings.HasSuffix(word, "shes") || strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "sses")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+func set(words ...string) map[string]bool {
+	m := make(map[string]bool, len(words))
+	for _, word := range words {
+		m[word] = true
+	}
+	return m
+}
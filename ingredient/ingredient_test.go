@@ -0,0 +1,118 @@
+package ingredient
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func quantity(r *Rational) string {
+	if r == nil {
+		return ""
+	}
+	return r.String()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line                  string
+		quantity, quantityMax string
+		unit, item, note      string
+	}{
+		{"1 1/2 cups flour, sifted", "3/2", "", "cup", "flour", "sifted"},
+		{"½ tsp salt", "1/2", "", "tsp", "salt", ""},
+		{"1½ Tablespoons olive oil", "3/2", "", "tbsp", "olive oil", ""},
+		{"1.5 l stock", "3/2", "", "l", "stock", ""},
+		{"6 to 7-ounce salmon fillets", "6", "7", "oz", "salmon fillets", ""},
+		{"2-3 cloves garlic, minced", "2", "3", "clove", "garlic", "minced"},
+		{"1/4 to 1/2 cup milk", "1/4", "1/2", "cup", "milk", ""},
+		{"1 (8 ounce) package cream cheese, softened", "1", "", "package", "cream cheese", "8 ounce, softened"},
+		{"1 (14 oz) can diced tomatoes", "1", "", "can", "diced tomatoes", "14 oz"},
+		{"2 14oz cans chickpeas", "2", "", "can", "chickpeas", "14oz"},
+		{"1 28-ounce can crushed tomatoes", "1", "", "can", "crushed tomatoes", "28-ounce"},
+		{"1 large head cauliflower", "1", "", "head", "cauliflower", "large"},
+		{"1 cup (about 4 oz) walnuts, toasted and chopped", "1", "", "cup", "walnuts", "about 4 oz, toasted and chopped"},
+		{"2 fl oz rum", "2", "", "fl oz", "rum", ""},
+		{"1 lb. ground beef", "1", "", "lb", "ground beef", ""},
+		{"2 lbs potatoes", "2", "", "lb", "potatoes", ""},
+		{"250 ml water", "250", "", "ml", "water", ""},
+		{"  3 eggs\r", "3", "", "", "eggs", ""},
+		{"2 cloves", "2", "", "", "cloves", ""},
+		{"pinch of salt", "", "", "pinch", "salt", ""},
+		{"salt to taste", "", "", "", "salt", "to taste"},
+		{"olive oil, for serving", "", "", "", "olive oil", "for serving"},
+		{"Freshly ground black pepper", "", "", "", "Freshly ground black pepper", ""},
+		{"For the sauce:", "", "", "", "", "For the sauce:"},
+		{"<hr>", "", "", "", "", ""},
+		{"1000001 eggs", "", "", "", "1000001 eggs", ""},
+	}
+	for _, test := range tests {
+		got := Parse(test.line)
+		if quantity(got.Quantity) != test.quantity || quantity(got.QuantityMax) != test.quantityMax ||
+			got.Unit != test.unit || got.Item != test.item || got.Note != test.note {
+			t.Errorf("Parse(%q) = {%s %s %q %q %q}, want {%s %s %q %q %q}", test.line,
+				quantity(got.Quantity), quantity(got.QuantityMax), got.Unit, got.Item, got.Note,
+				test.quantity, test.quantityMax, test.unit, test.item, test.note)
+		}
+	}
+}
+
+func TestParseKeepsOriginal(t *testing.T) {
+	if got := Parse("  1 cup sugar\r\n").Original; got != "1 cup sugar" {
+		t.Errorf("Original = %q, want \"1 cup sugar\"", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"1 1/2 cups flour, sifted", "1 1/2 cup flour, sifted"},
+		{"6 to 7-ounce salmon fillets", "6 to 7 oz salmon fillets"},
+		{"salt to taste", "salt, to taste"},
+		{"3 eggs", "3 eggs"},
+	}
+	for _, test := range tests {
+		got := Parse(test.line).String()
+		if got != test.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", test.line, got, test.want)
+		}
+		if again := Parse(got).String(); again != got {
+			t.Errorf("Parse(%q).String() = %q, want it unchanged", got, again)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var ingredients []Ingredient
+	data := `["1 ½ cups flour\r", {"quantity": "3/4", "unit": "tsp", "item": "salt"}]`
+	if err := json.Unmarshal([]byte(data), &ingredients); err != nil {
+		t.Fatal(err)
+	}
+	if got := ingredients[0]; quantity(got.Quantity) != "3/2" || got.Unit != "cup" || got.Original != "1 ½ cups flour" {
+		t.Errorf("string ingredient decoded as %+v", got)
+	}
+	if got := ingredients[1]; quantity(got.Quantity) != "3/4" || got.Item != "salt" || got.Original != "3/4 tsp salt" {
+		t.Errorf("object ingredient decoded as %+v", got)
+	}
+	for _, data := range []string{`[{"quantity": "x"}]`, `[{"quantity": "99999999"}]`} {
+		if err := json.Unmarshal([]byte(data), &ingredients); err == nil {
+			t.Errorf("json.Unmarshal(%s) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"2 large carrots, peeled", "carrot"},
+		{"1 (14 oz) can diced tomatoes", "diced tomato"},
+		{"3 tbsp fresh berries", "fresh berry"},
+		{"<hr>", ""},
+	}
+	for _, test := range tests {
+		if got := Normalize(Parse(test.line)); got != test.want {
+			t.Errorf("Normalize(Parse(%q)) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
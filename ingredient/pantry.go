@@ -0,0 +1,101 @@
+package ingredient
+
+import "regexp"
+
+// Conjunctions of compound ingredients like "salt and pepper" or "butter
+// or margarine".
+var (
+	alternatives = regexp.MustCompile(`(?i)\s+(?:and/)?or\s+`)
+	conjunctions = regexp.MustCompile(`(?i)\s*&\s*|\s+and\s+`)
+)
+
+// Pantry is a set of ingredients on hand.
+type Pantry struct {
+	items [][]string
+}
+
+// NewPantry returns a pantry holding the given ingredients. Quantities,
+// units and notes are ignored like in recipes.
+func NewPantry(lines []string) Pantry {
+	p := Pantry{}
+	for _, line := range lines {
+		if words := Words(Parse(line)); len(words) > 0 {
+			p.items = append(p.items, words)
+		}
+	}
+	return p
+}
+
+// Len returns the number of ingredients in the pantry.
+func (p Pantry) Len() int {
+	return len(p.items)
+}
+
+// Has reports whether the pantry holds the ingredient. An item covers
+// ingredients of the same name and of the name with qualifiers in front,
+// so "salt" covers "kosher salt" and "cheese" covers "grated parmesan
+// cheese", but "goat cheese" does not cover "cheddar cheese", "salt" does
+// not cover "salted butter" and "chicken" does not cover "chicken stock".
+// Compound ingredients need all their parts, like "salt and pepper", or
+// one of their alternatives, like "butter or margarine".
+func (p Pantry) Has(ing Ingredient) bool {
+	if p.holds(Words(ing)) {
+		// names like "half and half"
+		return true
+	}
+	for _, alternative := range alternatives.Split(ing.Item, -1) {
+		if p.hasAll(conjunctions.Split(alternative, -1)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAll reports whether the pantry covers every named part.
+func (p Pantry) hasAll(parts []string) bool {
+	found := false
+	for _, part := range parts {
+		name := Words(Ingredient{Item: part})
+		if len(name) == 0 {
+			continue
+		}
+		if !p.covers(name) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// holds reports whether an item is exactly the given name.
+func (p Pantry) holds(name []string) bool {
+	for _, item := range p.items {
+		if len(item) == len(name) && hasSuffix(name, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether an item is the given name or its last words.
+func (p Pantry) covers(name []string) bool {
+	for _, item := range p.items {
+		if hasSuffix(name, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSuffix(words, suffix []string) bool {
+	if len(suffix) > len(words) {
+		return false
+	}
+	offset := len(words) - len(suffix)
+	for i, word := range suffix {
+		if words[offset+i] != word {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package ingredient
+
+import "testing"
+
+func TestPantryHas(t *testing.T) {
+	tests := []struct {
+		pantry []string
+		line   string
+		want   bool
+	}{
+		{[]string{"salt"}, "1 tsp kosher salt", true},
+		{[]string{"salt"}, "salt to taste", true},
+		{[]string{"salt"}, "salt and pepper", false},
+		{[]string{"salt", "pepper"}, "salt and freshly ground black pepper, to taste", true},
+		{[]string{"pepper"}, "salt & pepper", false},
+		{[]string{"salt"}, "2 tbsp salted butter", false},
+		{[]string{"salt"}, "1/2 cup unsalted butter", false},
+		{[]string{"butter"}, "2 tbsp salted butter", true},
+		{[]string{"chicken"}, "2 cups chicken stock", false},
+		{[]string{"chicken stock"}, "2 cups chicken stock", true},
+		{[]string{"cheese"}, "1 cup grated parmesan cheese", true},
+		{[]string{"goat cheese"}, "1 cup cheddar cheese", false},
+		{[]string{"margarine"}, "2 tbsp butter or margarine", true},
+		{[]string{"2 lbs tomatoes"}, "1 (14 oz) can diced tomatoes", true},
+		{[]string{"half and half"}, "1 cup half and half", true},
+		{[]string{"salt"}, "<hr>", false},
+	}
+	for _, test := range tests {
+		if got := NewPantry(test.pantry).Has(Parse(test.line)); got != test.want {
+			t.Errorf("pantry %q has %q = %v, want %v", test.pantry, test.line, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package ingredient
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// vulgarFractions maps the unicode fraction characters to their values.
+var vulgarFractions = map[rune]Rational{
+	'½': {1, 2}, '⅓': {1, 3}, '⅔': {2, 3}, '¼': {1, 4}, '¾': {3, 4},
+	'⅕': {1, 5}, '⅖': {2, 5}, '⅗': {3, 5}, '⅘': {4, 5}, '⅙': {1, 6},
+	'⅚': {5, 6}, '⅐': {1, 7}, '⅛': {1, 8}, '⅜': {3, 8}, '⅝': {5, 8},
+	'⅞': {7, 8}, '⅑': {1, 9}, '⅒': {1, 10},
+}
+
+// Rational is an exact, non-negative quantity like 3/2. Quantities are kept
+// as fractions, since recipes measure in halves, thirds and quarters that
+// floating point numbers cannot represent exactly.
+//
+// It is written to JSON as text like "3/2" or "2" and to BSON as a
+// document with the numerator and denominator.
+type Rational struct {
+	Num int64 `bson:"num"`
+	Den int64 `bson:"den"`
+}
+
+// MaxQuantity is the largest quantity ParseRational accepts, and
+// maxDenominator the largest denominator. Quantities within these bounds
+// can be scaled and converted without overflowing.
+const (
+	MaxQuantity    = 1000000
+	maxDenominator = 1000000
+)
+
+// NewRational returns num/den in lowest terms.
+func NewRational(num, den int64) Rational {
+	if den == 0 {
+		return Rational{0, 1}
+	}
+	if den < 0 {
+		num, den = -num, -den
+	}
+	if g := gcd(abs(num), den); g > 1 {
+		num, den = num/g, den/g
+	}
+	return Rational{num, den}
+}
+
+// ParseRational parses integers, decimals, fractions, mixed numbers and
+// unicode fractions: "2", "1.5", "3/2", "1 1/2", "1½" and "½". Quantities
+// above MaxQuantity are rejected.
+func ParseRational(s string) (Rational, error) {
+	r, err := parseRational(s)
+	if err != nil {
+		return Rational{}, err
+	}
+	if r.Den > maxDenominator || r.Num/r.Den > MaxQuantity || (r.Num/r.Den == MaxQuantity && r.Num%r.Den != 0) {
+		return Rational{}, errors.Errorf("'%s' is larger than %d or too precise", strings.TrimSpace(s), MaxQuantity)
+	}
+	return r, nil
+}
+
+func parseRational(s string) (Rational, error) {
+	s = strings.TrimSpace(s)
+	invalid := errors.Errorf("'%s' is not a quantity", s)
+	if s == "" {
+		return Rational{}, invalid
+	}
+	if fields := strings.Fields(s); len(fields) == 2 {
+		whole, err := parseRational(fields[0])
+		if err != nil || whole.Den != 1 {
+			return Rational{}, invalid
+		}
+		part, err := parseRational(fields[1])
+		if err != nil || part.Den == 1 {
+			return Rational{}, invalid
+		}
+		return whole.Add(part), nil
+	} else if len(fields) > 2 {
+		return Rational{}, invalid
+	}
+	for r, value := range vulgarFractions {
+		if strings.HasSuffix(s, string(r)) {
+			whole := Rational{0, 1}
+			if prefix := strings.TrimSuffix(s, string(r)); prefix != "" {
+				n, err := strconv.ParseInt(prefix, 10, 64)
+				if err != nil || n < 0 {
+					return Rational{}, invalid
+				}
+				whole = Rational{n, 1}
+			}
+			return whole.Add(value), nil
+		}
+	}
+	if i := strings.IndexAny(s, "/⁄"); i >= 0 {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		num, err1 := strconv.ParseInt(s[:i], 10, 64)
+		den, err2 := strconv.ParseInt(s[i+size:], 10, 64)
+		if err1 != nil || err2 != nil || num < 0 || den <= 0 {
+			return Rational{}, invalid
+		}
+		return NewRational(num, den), nil
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits := len(s) - i - 1
+		if digits == 0 || digits > 6 {
+			return Rational{}, invalid
+		}
+		n, err := strconv.ParseInt(s[:i]+s[i+1:], 10, 64)
+		if err != nil || n < 0 {
+			return Rational{}, invalid
+		}
+		den := int64(1)
+		for ; digits > 0; digits-- {
+			den *= 10
+		}
+		return NewRational(n, den), nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return Rational{}, invalid
+	}
+	return Rational{n, 1}, nil
+}
+
+// Add returns r+o. Like Mul and Div, it computes exactly and rounds only
+// results that do not fit, see fromRat.
+func (r Rational) Add(o Rational) Rational {
+	return fromRat(new(big.Rat).Add(r.rat(), o.rat()))
+}
+
+// Mul returns r*o.
+func (r Rational) Mul(o Rational) Rational {
+	return fromRat(new(big.Rat).Mul(r.rat(), o.rat()))
+}
+
+// Div returns r/o, or 0 if o is zero.
+func (r Rational) Div(o Rational) Rational {
+	if o.Num == 0 {
+		return Rational{0, 1}
+	}
+	return fromRat(new(big.Rat).Quo(r.rat(), o.rat()))
+}
+
+// Less reports whether r < o.
+func (r Rational) Less(o Rational) bool {
+	return r.rat().Cmp(o.rat()) < 0
+}
+
+func (r Rational) rat() *big.Rat {
+	if r.Den == 0 {
+		return new(big.Rat)
+	}
+	return big.NewRat(r.Num, r.Den)
+}
+
+// fromRat converts x to a Rational. Values whose numerator or denominator
+// do not fit into an int64 are rounded to millionths, but not to zero, or
+// to integers if even those do not fit, and saturate at the limits of int64.
+func fromRat(x *big.Rat) Rational {
+	if x.Num().IsInt64() && x.Denom().IsInt64() {
+		return Rational{x.Num().Int64(), x.Denom().Int64()}
+	}
+	f, _ := x.Float64()
+	switch {
+	case math.Abs(f) < math.MaxInt64/1e6:
+		if r := NewRational(int64(math.Round(f*1e6)), 1e6); r.Num != 0 {
+			return r
+		}
+		// keep tiny quantities from vanishing
+		return Rational{int64(x.Sign()), 1e6}
+	case f >= math.MaxInt64:
+		return Rational{math.MaxInt64, 1}
+	case f <= math.MinInt64:
+		return Rational{-math.MaxInt64, 1}
+	}
+	return Rational{int64(math.Round(f)), 1}
+}
+
+// Float64 returns the value of r as a floating point number.
+func (r Rational) Float64() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// IsZero reports whether r is zero or unset.
+func (r Rational) IsZero() bool {
+	return r.Num == 0
+}
+
+// String returns r as an integer or an improper fraction like "3/2".
+func (r Rational) String() string {
+	if r.Den == 1 || r.Den == 0 {
+		return strconv.FormatInt(r.Num, 10)
+	}
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// Mixed returns r as an integer or a mixed number like "1 1/2".
+func (r Rational) Mixed() string {
+	if r.Den <= 1 || r.Num < r.Den {
+		return r.String()
+	}
+	whole := r.Num / r.Den
+	if rest := r.Num % r.Den; rest != 0 {
+		return fmt.Sprintf("%d %d/%d", whole, rest, r.Den)
+	}
+	return strconv.FormatInt(whole, 10)
+}
+
+func (r Rational) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rational) UnmarshalText(text []byte) error {
+	parsed, err := ParseRational(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
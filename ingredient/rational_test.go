@@ -0,0 +1,134 @@
+package ingredient
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseRational(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rational
+	}{
+		{"2", Rational{2, 1}},
+		{" 12 ", Rational{12, 1}},
+		{"1.5", Rational{3, 2}},
+		{"0.25", Rational{1, 4}},
+		{"3/2", Rational{3, 2}},
+		{"6/8", Rational{3, 4}},
+		{"1⁄3", Rational{1, 3}},
+		{"1 1/2", Rational{3, 2}},
+		{"2 3/4", Rational{11, 4}},
+		{"½", Rational{1, 2}},
+		{"1½", Rational{3, 2}},
+		{"1 ½", Rational{3, 2}},
+		{"⅔", Rational{2, 3}},
+		{"1000000", Rational{MaxQuantity, 1}},
+		{"0", Rational{0, 1}},
+	}
+	for _, test := range tests {
+		got, err := ParseRational(test.in)
+		if err != nil {
+			t.Errorf("ParseRational(%q) returned error: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseRational(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseRationalErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"a",
+		"-1",
+		"1/0",
+		"1/-2",
+		"1.",
+		"1.1234567",
+		"1/2 1/2",
+		"1 2",
+		"1 2 3",
+		"x½",
+		"1000001",
+		"1000000 1/2",
+		"1/1000001",
+		"9223372036854775807",
+		"99999999999999999999",
+		"9223372036854775807/1",
+	} {
+		if got, err := ParseRational(in); err == nil {
+			t.Errorf("ParseRational(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestRationalArithmetic(t *testing.T) {
+	half, third := Rational{1, 2}, Rational{1, 3}
+	if got := half.Add(third); got != (Rational{5, 6}) {
+		t.Errorf("1/2 + 1/3 = %v, want 5/6", got)
+	}
+	if got := half.Mul(third); got != (Rational{1, 6}) {
+		t.Errorf("1/2 * 1/3 = %v, want 1/6", got)
+	}
+	if got := half.Div(third); got != (Rational{3, 2}) {
+		t.Errorf("1/2 / 1/3 = %v, want 3/2", got)
+	}
+	if got := half.Div(Rational{0, 1}); got != (Rational{0, 1}) {
+		t.Errorf("1/2 / 0 = %v, want 0", got)
+	}
+	if !third.Less(half) || half.Less(third) {
+		t.Errorf("Less does not order 1/3 before 1/2")
+	}
+	big := Rational{math.MaxInt64, 1}
+	if got := big.Mul(big); got != big {
+		t.Errorf("MaxInt64 * MaxInt64 = %v, want it to saturate at %v", got, big)
+	}
+	if got := big.Add(big); got != big {
+		t.Errorf("MaxInt64 + MaxInt64 = %v, want it to saturate at %v", got, big)
+	}
+	if !(Rational{1, math.MaxInt64}).Less(Rational{1, math.MaxInt64 - 1}) {
+		t.Errorf("Less overflows for large denominators")
+	}
+	fine := Rational{1, math.MaxInt64 - 1}.Mul(Rational{3, math.MaxInt64 - 2})
+	if fine.Num <= 0 || fine.Den <= 0 {
+		t.Errorf("product of tiny fractions = %v, want a positive fraction", fine)
+	}
+}
+
+func TestRationalFormat(t *testing.T) {
+	tests := []struct {
+		in         Rational
+		str, mixed string
+	}{
+		{Rational{0, 1}, "0", "0"},
+		{Rational{2, 1}, "2", "2"},
+		{Rational{1, 2}, "1/2", "1/2"},
+		{Rational{3, 2}, "3/2", "1 1/2"},
+		{Rational{11, 4}, "11/4", "2 3/4"},
+		{Rational{8, 4}, "8/4", "2"},
+	}
+	for _, test := range tests {
+		if got := test.in.String(); got != test.str {
+			t.Errorf("%#v.String() = %q, want %q", test.in, got, test.str)
+		}
+		if got := test.in.Mixed(); got != test.mixed {
+			t.Errorf("%#v.Mixed() = %q, want %q", test.in, got, test.mixed)
+		}
+	}
+}
+
+func TestRationalText(t *testing.T) {
+	var r Rational
+	if err := r.UnmarshalText([]byte("1 1/2")); err != nil || r != (Rational{3, 2}) {
+		t.Fatalf("UnmarshalText(\"1 1/2\") = %v, %v", r, err)
+	}
+	text, _ := r.MarshalText()
+	if string(text) != "3/2" {
+		t.Errorf("MarshalText() = %q, want \"3/2\"", text)
+	}
+	if err := r.UnmarshalText([]byte("123456789012")); err == nil {
+		t.Errorf("UnmarshalText accepted a quantity above MaxQuantity")
+	}
+}
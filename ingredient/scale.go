@@ -0,0 +1,121 @@
+package ingredient
+
+import "math"
+
+// measure is a unit of a family of convertible units, like tsp, tbsp and
+// cup. Size is the unit in the smallest unit of its family. An amount is
+// given in the largest unit of which it makes at least Min.
+type measure struct {
+	unit string
+	size Rational
+	min  Rational
+}
+
+// families are the convertible units, largest first. Units missing here,
+// like cans or cloves, are never converted.
+var families = [][]measure{
+	{{"cup", Rational{48, 1}, Rational{1, 4}}, {"tbsp", Rational{3, 1}, Rational{1, 1}}, {"tsp", Rational{1, 1}, Rational{0, 1}}},
+	{{"lb", Rational{16, 1}, Rational{1, 1}}, {"oz", Rational{1, 1}, Rational{0, 1}}},
+	{{"kg", Rational{1000, 1}, Rational{1, 1}}, {"g", Rational{1, 1}, Rational{0, 1}}},
+	{{"l", Rational{1000, 1}, Rational{1, 1}}, {"ml", Rational{1, 1}, Rational{0, 1}}},
+}
+
+// fractions are the parts of a unit measured in a kitchen. Amounts of
+// less than one unit are rounded to eighths and thirds, larger ones to
+// quarters and thirds.
+var (
+	smallFractions = []Rational{{0, 1}, {1, 8}, {1, 4}, {1, 3}, {3, 8}, {1, 2}, {5, 8}, {2, 3}, {3, 4}, {7, 8}, {1, 1}}
+	largeFractions = []Rational{{0, 1}, {1, 4}, {1, 3}, {1, 2}, {2, 3}, {3, 4}, {1, 1}}
+)
+
+// Scale returns the ingredient for factor times the amount. The quantity
+// is converted to the largest sensible unit, so that 48 tsp become 1 cup,
+// and rounded to kitchen fractions. Ingredients without a quantity, like
+// "salt to taste", are returned unchanged.
+func (i Ingredient) Scale(factor Rational) Ingredient {
+	if i.Quantity == nil {
+		return i
+	}
+	quantity := i.Quantity.Mul(factor)
+	var quantityMax *Rational
+	if i.QuantityMax != nil {
+		max := i.QuantityMax.Mul(factor)
+		quantityMax = &max
+	}
+	unit, quantity, quantityMax := convert(i.Unit, quantity, quantityMax)
+	quantity = round(quantity)
+	if quantityMax != nil {
+		max := round(*quantityMax)
+		quantityMax = &max
+	}
+	scaled := Ingredient{
+		Quantity:    &quantity,
+		QuantityMax: quantityMax,
+		Unit:        unit,
+		Item:        i.Item,
+		Note:        i.Note,
+	}
+	scaled.Original = scaled.String()
+	return scaled
+}
+
+// convert expresses quantity and quantityMax in the largest unit of the
+// family of unit of which quantity makes at least the unit's minimum.
+func convert(unit string, quantity Rational, quantityMax *Rational) (string, Rational, *Rational) {
+	for _, family := range families {
+		var from *measure
+		for k := range family {
+			if family[k].unit == unit {
+				from = &family[k]
+			}
+		}
+		if from == nil {
+			continue
+		}
+		base := quantity.Mul(from.size)
+		for _, to := range family {
+			if amount := base.Div(to.size); !amount.Less(to.min) {
+				if quantityMax != nil {
+					max := quantityMax.Mul(from.size).Div(to.size)
+					quantityMax = &max
+				}
+				return to.unit, amount, quantityMax
+			}
+		}
+	}
+	return unit, quantity, quantityMax
+}
+
+// round rounds quantity to the nearest kitchen fraction, and to whole
+// units from 10 on. Positive quantities never round to zero.
+func round(quantity Rational) Rational {
+	if quantity.IsZero() {
+		return quantity
+	}
+	if quantity.Num/quantity.Den >= 10 {
+		// integer arithmetic, since huge quantities do not survive a
+		// round trip through float64
+		whole, rest := quantity.Num/quantity.Den, quantity.Num%quantity.Den
+		if rest >= quantity.Den-rest {
+			whole++
+		}
+		return Rational{whole, 1}
+	}
+	value := quantity.Float64()
+	whole := math.Floor(value)
+	fractions := smallFractions
+	if whole >= 1 {
+		fractions = largeFractions
+	}
+	nearest := fractions[0]
+	for _, fraction := range fractions[1:] {
+		if math.Abs(whole+fraction.Float64()-value) < math.Abs(whole+nearest.Float64()-value) {
+			nearest = fraction
+		}
+	}
+	rounded := Rational{int64(whole), 1}.Add(nearest)
+	if rounded.IsZero() {
+		return smallFractions[1]
+	}
+	return rounded
+}
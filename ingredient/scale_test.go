@@ -0,0 +1,74 @@
+package ingredient
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		line   string
+		factor Rational
+		want   string
+	}{
+		// unit promotion
+		{"48 tsp sugar", Rational{1, 1}, "1 cup sugar"},
+		{"16 tsp sugar", Rational{1, 1}, "1/3 cup sugar"},
+		{"4 tsp sugar", Rational{3, 1}, "1/4 cup sugar"},
+		{"1 tbsp butter", Rational{3, 1}, "3 tbsp butter"},
+		{"1 tsp vanilla", Rational{3, 1}, "1 tbsp vanilla"},
+		{"8 oz cheese", Rational{5, 2}, "1 1/4 lb cheese"},
+		{"250 g rice", Rational{4, 1}, "1 kg rice"},
+		{"500 ml water", Rational{3, 1}, "1 1/2 l water"},
+		// unit demotion
+		{"1/4 cup oil", Rational{1, 4}, "1 tbsp oil"},
+		{"1 lb beef", Rational{1, 4}, "4 oz beef"},
+		{"1 1/2 cups flour", Rational{3, 1}, "4 1/2 cup flour"},
+		{"3/4 cup flour, sifted", Rational{6, 1}, "4 1/2 cup flour, sifted"},
+		// rounding to kitchen fractions
+		{"3 eggs", Rational{1, 4}, "3/4 eggs"},
+		{"1 cup milk", Rational{5, 12}, "3/8 cup milk"},
+		{"1 cup milk", Rational{7, 3}, "2 1/3 cup milk"},
+		{"7 potatoes", Rational{3, 2}, "11 potatoes"},
+		{"1/8 tsp cloves", Rational{1, 4}, "1/8 tsp cloves"},
+		// ranges keep the unit of their lower end
+		{"6 to 7-ounce salmon fillets", Rational{3, 1}, "1 to 1 1/3 lb salmon fillets"},
+		// units without conversions and lines without quantity
+		{"1 (14 oz) can tomatoes", Rational{3, 1}, "3 can tomatoes, 14 oz"},
+		{"salt to taste", Rational{3, 1}, "salt, to taste"},
+	}
+	for _, test := range tests {
+		got := Parse(test.line).Scale(test.factor)
+		if got.String() != test.want {
+			t.Errorf("Parse(%q).Scale(%v) = %q, want %q", test.line, test.factor, got.String(), test.want)
+		}
+		if got.Quantity != nil && got.Original != got.String() {
+			t.Errorf("Parse(%q).Scale(%v).Original = %q, want %q", test.line, test.factor, got.Original, got.String())
+		}
+	}
+}
+
+func TestScaleLargeFactors(t *testing.T) {
+	tests := []struct {
+		line   string
+		factor Rational
+	}{
+		{"1000000 cups flour", Rational{math.MaxInt64, 1}},
+		{"1000000 tsp flour", Rational{math.MaxInt64, 1}},
+		{"999999 3/7 eggs", Rational{math.MaxInt64, 3}},
+		{"1/1000000 tsp salt", Rational{1, math.MaxInt64}},
+		{"6 to 7-ounce salmon fillets", Rational{math.MaxInt64 - 1, 1}},
+	}
+	for _, test := range tests {
+		got := Parse(test.line).Scale(test.factor)
+		for _, q := range []*Rational{got.Quantity, got.QuantityMax} {
+			if q != nil && (q.Num <= 0 || q.Den <= 0) {
+				t.Errorf("Parse(%q).Scale(%v) = %q, want a positive quantity", test.line, test.factor, got.String())
+			}
+		}
+	}
+	huge := Ingredient{Quantity: &Rational{math.MaxInt64, 1}, Item: "eggs"}
+	if got := huge.Scale(Rational{math.MaxInt64, 1}); got.Quantity.Num != math.MaxInt64 {
+		t.Errorf("Scale of a huge quantity = %q, want it to saturate", got.String())
+	}
+}
@@ -0,0 +1,29 @@
+// Command mockoidc serves a mock OpenID Connect provider for trying the
+// OIDC sign-in locally:
+//
+//	go run ./misc/mockoidc -addr localhost:9000 -client recipes-api
+//	go run . -oidc.issuer http://localhost:9000 -oidc.clientID recipes-api \
+//		-oidc.redirectURL http://localhost:8080/oidc/callback
+//
+// Opening http://localhost:8080/oidc/login?login_hint=bob then signs in as bob.
+package main
+
+import (
+	"flag"
+	"local/gin/gin-recipes-api/oidc/oidctest"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	clientID := flag.String("client", "recipes-api", "client ID accepted by the provider")
+	flag.Parse()
+
+	srv, err := oidctest.NewServer("http://"+*addr, *clientID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Mock OpenID provider %s for client %s", srv.Issuer, *clientID)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
@@ -2,7 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/password"
 	"log"
 	"os"
 
@@ -26,12 +27,20 @@ func main() {
 	}
 
 	collection := client.Database(os.Getenv("MONGO_DATABASE")).Collection("users")
-	h := sha256.New()
 
-	for username, password := range users {
-		_, err := collection.InsertOne(ctx, bson.M{
+	for username, plain := range users {
+		hashed, err := password.Hash(plain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		role := models.RoleAuthor
+		if username == "admin" {
+			role = models.RoleAdmin
+		}
+		_, err = collection.InsertOne(ctx, bson.M{
 			"username": username,
-			"password": string(h.Sum([]byte(password))),
+			"password": hashed,
+			"role":     role,
 		})
 		if err != nil {
 			log.Fatal(err)
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// APIKey grants machine-to-machine clients access on behalf of a user.
+// Only a digest of the key is stored; the key itself is shown once on creation.
+type APIKey struct {
+	ID         string       `json:"id" bson:"_id"`
+	Username   string       `json:"username" bson:"username"`
+	Name       string       `json:"name" bson:"name"`
+	Hash       string       `json:"-" bson:"hash"`
+	Scopes     []Permission `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time    `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  time.Time    `json:"expiresAt" bson:"expiresAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt" bson:"lastUsedAt"`
+}
+
+// HasScope reports whether the key was granted p.
+func (k APIKey) HasScope(p Permission) bool {
+	for _, scope := range k.Scopes {
+		if scope == p {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"local/gin/gin-recipes-api/ingredient"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -9,10 +10,18 @@ import (
 // swagger:parameters recipes newRecipe
 type Recipe struct {
 	//swagger:ignore
-	ID           primitive.ObjectID `json:"id" bson:"_id"`
-	Name         string             `json:"name" bson:"name"`
-	Tags         []string           `json:"tags" bson:"tags"`
-	Ingredients  []string           `json:"ingredients" bson:"ingredients"`
-	Instructions []string           `json:"instructions" bson:"instructions"`
-	PublishedAt  time.Time          `json:"publishedAt" bson:"publishedAt"`
+	ID           primitive.ObjectID      `json:"id" bson:"_id"`
+	Name         string                  `json:"name" bson:"name"`
+	Tags         []string                `json:"tags" bson:"tags"`
+	Ingredients  []ingredient.Ingredient `json:"ingredients" bson:"ingredients"`
+	Instructions []string                `json:"instructions" bson:"instructions"`
+	// Servings is the number of people the recipe feeds, 0 if unknown.
+	Servings    int       `json:"servings,omitempty" bson:"servings,omitempty" binding:"min=0"`
+	PublishedAt time.Time `json:"publishedAt" bson:"publishedAt"`
+	//swagger:ignore
+	CreatedBy string `json:"createdBy" bson:"createdBy"`
+	//swagger:ignore
+	UpdatedBy string `json:"updatedBy" bson:"updatedBy"`
+	//swagger:ignore
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
 }
@@ -0,0 +1,68 @@
+package models
+
+// Role determines what a user is allowed to do.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleAuthor Role = "author"
+	RoleReader Role = "reader"
+)
+
+// Permission names an action guarded by role-based access control.
+type Permission string
+
+const (
+	PermCreateRecipe Permission = "recipes:create"
+	// PermUpdateRecipe and PermDeleteRecipe allow modifying recipes
+	// created by the user; the Any variants allow modifying all recipes.
+	PermUpdateRecipe    Permission = "recipes:update"
+	PermUpdateAnyRecipe Permission = "recipes:update:any"
+	PermDeleteRecipe    Permission = "recipes:delete"
+	PermDeleteAnyRecipe Permission = "recipes:delete:any"
+	PermManageUsers     Permission = "users:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermCreateRecipe,
+		PermUpdateRecipe, PermUpdateAnyRecipe,
+		PermDeleteRecipe, PermDeleteAnyRecipe,
+		PermManageUsers,
+	},
+	RoleEditor: {
+		PermCreateRecipe,
+		PermUpdateRecipe, PermUpdateAnyRecipe,
+		PermDeleteRecipe,
+	},
+	RoleAuthor: {
+		PermCreateRecipe,
+		PermUpdateRecipe,
+		PermDeleteRecipe,
+	},
+	RoleReader: {},
+}
+
+// Valid reports whether p is one of the known permissions.
+func (p Permission) Valid() bool {
+	return RoleAdmin.Can(p)
+}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants the permission. Unknown roles,
+// including the empty role, grant nothing; the stores make users created
+// before roles existed authors.
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
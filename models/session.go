@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session is a cookie session of a signed-in user.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	// Current marks the session the listing was requested with.
+	Current bool `json:"current"`
+}
@@ -1,6 +1,27 @@
 package models
 
+import "time"
+
 type User struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username  string    `json:"username" bson:"username"`
+	Password  string    `json:"password" bson:"password"`
+	Email     string    `json:"email" bson:"email,omitempty"`
+	Role      Role      `json:"role" bson:"role,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt,omitempty"`
+	// Subject is the `sub` claim of the user at the OpenID Connect identity
+	// provider; it is empty for users who sign in with a password.
+	Subject string `json:"-" bson:"subject,omitempty"`
+	TOTP    TOTP   `json:"-" bson:"totp,omitempty"`
+}
+
+// TOTP holds the two-factor authentication settings of a user.
+type TOTP struct {
+	// Secret is set on enrollment; Enabled once the user confirmed it
+	// with a valid code.
+	Secret  string `bson:"secret,omitempty"`
+	Enabled bool   `bson:"enabled,omitempty"`
+	// LastCounter is the period of the last accepted code, see totp.Validate.
+	LastCounter int64 `bson:"lastCounter,omitempty"`
+	// RecoveryCodes are the digests of the unused recovery codes.
+	RecoveryCodes []string `bson:"recoveryCodes,omitempty"`
 }
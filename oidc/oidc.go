@@ -0,0 +1,340 @@
+// Package oidc implements the relying party side of the OpenID Connect
+// authorization code flow with PKCE (RFC 7636).
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Identity is the verified identity of a user signed in at the provider.
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Provider is an OpenID Connect identity provider.
+type Provider interface {
+	// AuthCodeURL returns the URL of the authorization endpoint the user
+	// is redirected to. The code challenge is derived from verifier.
+	AuthCodeURL(state, nonce, verifier string) string
+	// Exchange redeems the authorization code and returns the identity
+	// from the verified ID token, which must carry nonce.
+	Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error)
+}
+
+// Config identifies the API as a client of the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// metadata is the part of the provider metadata used by Client, see
+// OpenID Connect Discovery 1.0 section 3.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client is a Provider talking to an OpenID Connect compliant identity
+// provider. ID tokens must be signed with RS256.
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+	metadata   metadata
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// Discover fetches the metadata and signing keys of the provider at
+// cfg.Issuer. httpClient may be nil to use a client with a 10s timeout.
+func Discover(ctx context.Context, cfg Config, httpClient *http.Client) (*Client, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	c := &Client{
+		cfg:        cfg,
+		httpClient: httpClient,
+	}
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, wellKnown, &c.metadata); err != nil {
+		return nil, errors.Wrap(err, "While fetching OpenID provider metadata")
+	}
+	if c.metadata.Issuer != cfg.Issuer {
+		return nil, errors.Errorf("issuer %q in provider metadata does not match %q", c.metadata.Issuer, cfg.Issuer)
+	}
+	if err := c.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) AuthCodeURL(state, nonce, verifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.metadata.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// tokenResponse is the response of the token endpoint, see RFC 6749 section 5.
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if c.cfg.ClientSecret == "" {
+		form.Set("client_id", c.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "While redeeming authorization code")
+	}
+	defer res.Body.Close()
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token); err != nil {
+		return Identity{}, errors.Wrap(err, "While decoding token response")
+	}
+	if token.Error != "" {
+		return Identity{}, errors.Errorf("token endpoint returned %s: %s", token.Error, token.ErrorDescription)
+	}
+	if res.StatusCode != http.StatusOK || token.IDToken == "" {
+		return Identity{}, errors.Errorf("token endpoint returned %s without ID token", res.Status)
+	}
+	return c.verify(ctx, token.IDToken, nonce)
+}
+
+// idTokenClaims holds the claims of an ID token used by Client. jwt.StandardClaims
+// cannot be used as the audience of an ID token may be an array.
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// clockSkew is the tolerated difference between our clock and the provider's.
+const clockSkew = time.Minute
+
+func (c *idTokenClaims) Valid() error {
+	now := time.Now()
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("ID token has expired")
+	}
+	if time.Unix(c.IssuedAt, 0).After(now.Add(clockSkew)) {
+		return errors.New("ID token is issued in the future")
+	}
+	return nil
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the signature and claims of an ID token as described in
+// OpenID Connect Core 1.0 section 3.1.3.7.
+func (c *Client) verify(ctx context.Context, idToken, nonce string) (Identity, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return c.key(ctx, kid)
+	})
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "While verifying ID token")
+	}
+	switch {
+	case claims.Issuer != c.cfg.Issuer:
+		return Identity{}, errors.Errorf("ID token issuer %q does not match %q", claims.Issuer, c.cfg.Issuer)
+	case !claims.Audience.contains(c.cfg.ClientID):
+		return Identity{}, errors.New("ID token is not intended for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.cfg.ClientID:
+		return Identity{}, errors.New("ID token is not authorized for this client")
+	case claims.Nonce != nonce:
+		return Identity{}, errors.New("ID token nonce does not match")
+	case claims.Subject == "":
+		return Identity{}, errors.New("ID token has no subject")
+	}
+	return Identity{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// key returns the signing key with the given ID. The keys are fetched again
+// once if the ID is unknown, so that key rotation at the provider is picked up.
+func (c *Client) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if err := c.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.Errorf("unknown signing key %q", kid)
+}
+
+// jwk is an RSA JSON Web Key, see RFC 7517 and RFC 7518 section 6.3.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (c *Client) refreshKeys(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := c.getJSON(ctx, c.metadata.JWKSURI, &set); err != nil {
+		return errors.Wrap(err, "While fetching OpenID provider keys")
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return errors.Wrapf(err, "While decoding modulus of key %q", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return errors.Wrapf(err, "While decoding exponent of key %q", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return errors.Errorf("GET %s returned %s", url, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// RandomString returns a random URL-safe string suitable as state, nonce
+// or PKCE code verifier.
+func RandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "While generating random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,167 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrInvalidHash is returned when an encoded hash cannot be parsed.
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Params are the argon2id parameters stored alongside each hash.
+type Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultParams follow the recommendations of RFC 9106 for memory
+// constrained environments.
+var DefaultParams = Params{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// Hash derives an argon2id hash of password with a random salt and
+// returns it in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func Hash(password string) (string, error) {
+	return hashWithParams(password, DefaultParams)
+}
+
+func hashWithParams(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "While generating salt")
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash string
+)
+
+// VerifyDummy verifies password against a throwaway hash and ignores the
+// result. It takes as long as Verify does for an existing user, so that
+// callers can answer for unknown users as slowly as for wrong passwords.
+func VerifyDummy(password string) {
+	dummyOnce.Do(func() {
+		dummyHash, _ = Hash("")
+	})
+	Verify(password, dummyHash)
+}
+
+// Verify reports whether password matches the encoded hash. needsRehash is
+// true if the password matched but the hash is in the legacy format or was
+// created with other parameters than DefaultParams, so the caller should
+// store a fresh Hash of the password.
+func Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		ok := verifyLegacy(password, encoded)
+		return ok, ok, nil
+	}
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+	return true, p != DefaultParams, nil
+}
+
+// verifyLegacy checks hashes created by the original sign-in code, which
+// stored the password bytes followed by the SHA-256 digest of nothing.
+func verifyLegacy(password, encoded string) bool {
+	hash := sha256.New()
+	legacy := hash.Sum([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(encoded), legacy) == 1
+}
+
+func decode(encoded string) (Params, []byte, []byte, error) {
+	var p Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// MinLength and MaxLength bound the length of acceptable passwords.
+const (
+	MinLength = 10
+	MaxLength = 256
+)
+
+// Validate checks password against the strength rules: it must have
+// between MinLength and MaxLength characters, contain at least three of
+// lower case letters, upper case letters, digits and other characters,
+// and must not contain the username.
+func Validate(password, username string) error {
+	n := utf8.RuneCountInString(password)
+	if n < MinLength {
+		return errors.Errorf("password must have at least %d characters", MinLength)
+	}
+	if n > MaxLength {
+		return errors.Errorf("password must have at most %d characters", MaxLength)
+	}
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < 3 {
+		return errors.New("password must contain at least three of lower case letters, upper case letters, digits and symbols")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+	return nil
+}
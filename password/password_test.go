@@ -0,0 +1,81 @@
+package password
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestHashVerify(t *testing.T) {
+	hashed, err := Hash("Tr0ub4dor&3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hashed, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("Hash() = %q, want an argon2id PHC string with the default parameters", hashed)
+	}
+	if again, _ := Hash("Tr0ub4dor&3"); again == hashed {
+		t.Errorf("Hash() returned the same hash twice, want a random salt")
+	}
+	if ok, needsRehash, err := Verify("Tr0ub4dor&3", hashed); !ok || needsRehash || err != nil {
+		t.Errorf("Verify(right password) = %v, %v, %v, want true, false, nil", ok, needsRehash, err)
+	}
+	if ok, needsRehash, err := Verify("tr0ub4dor&3", hashed); ok || needsRehash || err != nil {
+		t.Errorf("Verify(wrong password) = %v, %v, %v, want false, false, nil", ok, needsRehash, err)
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	// the original sign-in code stored sha256.New().Sum(password)
+	legacy := string(sha256.New().Sum([]byte("packt")))
+	if ok, needsRehash, err := Verify("packt", legacy); !ok || !needsRehash || err != nil {
+		t.Errorf("Verify(legacy hash) = %v, %v, %v, want true, true, nil", ok, needsRehash, err)
+	}
+	if ok, needsRehash, _ := Verify("packT", legacy); ok || needsRehash {
+		t.Errorf("Verify(wrong password, legacy hash) = %v, %v, want false, false", ok, needsRehash)
+	}
+}
+
+func TestVerifyOutdatedParams(t *testing.T) {
+	weak := Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 8, KeyLen: 16}
+	hashed, err := hashWithParams("Tr0ub4dor&3", weak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, needsRehash, err := Verify("Tr0ub4dor&3", hashed); !ok || !needsRehash || err != nil {
+		t.Errorf("Verify(outdated parameters) = %v, %v, %v, want true, true, nil", ok, needsRehash, err)
+	}
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	for _, encoded := range []string{
+		"$argon2id$",
+		"$argon2id$v=18$m=65536,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=3,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!!$a2V5",
+	} {
+		if ok, _, err := Verify("Tr0ub4dor&3", encoded); ok || err != ErrInvalidHash {
+			t.Errorf("Verify(%q) = %v, %v, want false, ErrInvalidHash", encoded, ok, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"Tr0ub4dor&3", true},
+		{"correct horse battery staple", false},
+		{"Correct horse battery staple", true},
+		{"Short1!", false},
+		{"alllowercase1", false},
+		{"My name is Alice 1", false},
+		{strings.Repeat("Aa1", 86), false},
+	}
+	for _, test := range tests {
+		if err := Validate(test.password, "alice"); (err == nil) != test.valid {
+			t.Errorf("Validate(%q) = %v, want valid %v", test.password, err, test.valid)
+		}
+	}
+}
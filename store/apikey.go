@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"local/gin/gin-recipes-api/models"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// APIKeyStore abstracts the persistence of API keys.
+type APIKeyStore interface {
+	// CreateAPIKey stores a new API key.
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// ListAPIKeys returns all API keys of the given user.
+	ListAPIKeys(ctx context.Context, username string) ([]models.APIKey, error)
+	// GetAPIKeyByHash returns the API key with the given digest.
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	// DeleteAPIKey removes the API key with the given ID owned by username.
+	DeleteAPIKey(ctx context.Context, username, id string) error
+	// TouchAPIKey records that the API key with the given ID was used at t.
+	TouchAPIKey(ctx context.Context, id string, t time.Time) error
+}
+
+// MongoAPIKeyStore is an APIKeyStore backed by a MongoDB collection.
+type MongoAPIKeyStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoAPIKeyStore(collection *mongo.Collection) *MongoAPIKeyStore {
+	return &MongoAPIKeyStore{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the indexes used to look up keys by digest and user.
+func (s *MongoAPIKeyStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "username", Value: 1}},
+		},
+	})
+	return err
+}
+
+func (s *MongoAPIKeyStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	_, err := s.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (s *MongoAPIKeyStore) ListAPIKeys(ctx context.Context, username string) ([]models.APIKey, error) {
+	cur, err := s.collection.Find(ctx, bson.M{"username": username})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	keys := make([]models.APIKey, 0)
+	for cur.Next(ctx) {
+		var key models.APIKey
+		if err := cur.Decode(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, cur.Err()
+}
+
+func (s *MongoAPIKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	var key models.APIKey
+	err := s.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return key, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (s *MongoAPIKeyStore) DeleteAPIKey(ctx context.Context, username, id string) error {
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": id, "username": username})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *MongoAPIKeyStore) TouchAPIKey(ctx context.Context, id string, t time.Time) error {
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": t}})
+	return err
+}
+
+// MemoryAPIKeyStore is a thread-safe APIKeyStore keeping all keys in memory.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]models.APIKey
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys: make(map[string]models.APIKey),
+	}
+}
+
+func (s *MemoryAPIKeyStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = *key
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) ListAPIKeys(ctx context.Context, username string) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]models.APIKey, 0)
+	for _, key := range s.keys {
+		if key.Username == username {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return models.APIKey{}, ErrAPIKeyNotFound
+}
+
+func (s *MemoryAPIKeyStore) DeleteAPIKey(ctx context.Context, username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.Username != username {
+		return ErrAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) TouchAPIKey(ctx context.Context, id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = &t
+	s.keys[id] = key
+	return nil
+}
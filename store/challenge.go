@@ -0,0 +1,81 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidChallenge is returned for unknown, expired or exhausted sign-in challenges.
+var ErrInvalidChallenge = errors.New("invalid or expired sign-in challenge")
+
+// ChallengeStore keeps the sign-ins waiting for a second factor in Redis.
+// A challenge is issued once the password has been verified and allows a
+// limited number of attempts to enter the code. The keys are
+//
+//	challenge:<sha256 of token>   hash with username and attempts
+type ChallengeStore struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+	maxAttempts int64
+}
+
+func NewChallengeStore(redisClient *redis.Client, ttl time.Duration, maxAttempts int) *ChallengeStore {
+	return &ChallengeStore{
+		redisClient: redisClient,
+		ttl:         ttl,
+		maxAttempts: int64(maxAttempts),
+	}
+}
+
+// Issue starts a challenge for username and returns its token.
+func (s *ChallengeStore) Issue(username string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "While generating challenge token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(challengeKey(token), "username", username)
+		pipe.Expire(challengeKey(token), s.ttl)
+		return nil
+	})
+	return token, err
+}
+
+// Attempt counts an attempt to answer the challenge and returns the
+// username it was issued for. The challenge is dropped after maxAttempts.
+func (s *ChallengeStore) Attempt(token string) (string, error) {
+	key := challengeKey(token)
+	var username *redis.StringCmd
+	var attempts *redis.IntCmd
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		username = pipe.HGet(key, "username")
+		attempts = pipe.HIncrBy(key, "attempts", 1)
+		return nil
+	})
+	if err == redis.Nil || username.Val() == "" {
+		s.redisClient.Del(key)
+		return "", ErrInvalidChallenge
+	}
+	if err != nil {
+		return "", err
+	}
+	if attempts.Val() > s.maxAttempts {
+		s.redisClient.Del(key)
+		return "", ErrInvalidChallenge
+	}
+	return username.Val(), nil
+}
+
+// Finish drops the challenge once it has been answered.
+func (s *ChallengeStore) Finish(token string) error {
+	return s.redisClient.Del(challengeKey(token)).Err()
+}
+
+func challengeKey(token string) string {
+	return "challenge:" + digest(token)
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"local/gin/gin-recipes-api/ingredient"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/tagquery"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a thread-safe RecipeStore keeping all recipes in memory.
+// It is meant for local development and tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	recipes []models.Recipe
+}
+
+// NewMemoryStore returns a MemoryStore seeded with the given recipes.
+func NewMemoryStore(recipes []models.Recipe) *MemoryStore {
+	s := &MemoryStore{
+		recipes: make([]models.Recipe, 0, len(recipes)),
+	}
+	for _, recipe := range recipes {
+		s.recipes = append(s.recipes, copyRecipe(recipe))
+	}
+	return s
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]models.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	recipes := make([]models.Recipe, 0, len(s.recipes))
+	for _, recipe := range s.recipes {
+		recipes = append(recipes, copyRecipe(recipe))
+	}
+	return recipes, nil
+}
+
+func (s *MemoryStore) ListPage(ctx context.Context, query PageQuery) (Page, error) {
+	recipes, _ := s.List(ctx)
+	direction := 1
+	if query.Desc {
+		direction = -1
+	}
+	sort.SliceStable(recipes, func(i, j int) bool {
+		return direction*CursorAt(recipes[j], query.SortBy).compare(recipes[i], query.SortBy) < 0
+	})
+	start := 0
+	if query.After != nil {
+		start = sort.Search(len(recipes), func(i int) bool {
+			return direction*query.After.compare(recipes[i], query.SortBy) > 0
+		})
+	}
+	end := len(recipes)
+	if query.Limit > 0 && start+query.Limit+1 < end {
+		end = start + query.Limit + 1
+	}
+	return newPage(recipes[start:end], int64(len(recipes)), query), nil
+}
+
+func (s *MemoryStore) ListByAuthor(ctx context.Context, username string) ([]models.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	recipes := make([]models.Recipe, 0)
+	for _, recipe := range s.recipes {
+		if recipe.CreatedBy == username {
+			recipes = append(recipes, copyRecipe(recipe))
+		}
+	}
+	return recipes, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (models.Recipe, error) {
+	rID, err := parseID(id)
+	if err != nil {
+		return models.Recipe{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.index(rID)
+	if i < 0 {
+		return models.Recipe{}, ErrNotFound
+	}
+	return copyRecipe(s.recipes[i]), nil
+}
+
+func (s *MemoryStore) Search(ctx context.Context, query tagquery.Expr) ([]models.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	recipes := make([]models.Recipe, 0)
+	for _, recipe := range s.recipes {
+		if query.Match(recipe.Tags) {
+			recipes = append(recipes, copyRecipe(recipe))
+		}
+	}
+	return recipes, nil
+}
+
+func (s *MemoryStore) SearchText(ctx context.Context, query TextQuery, limit int) ([]TextMatch, error) {
+	recipes, _ := s.List(ctx)
+	return rankText(recipes, query, limit), nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, recipe *models.Recipe) error {
+	recipe.ID = primitive.NewObjectID()
+	recipe.PublishedAt = time.Now()
+	recipe.UpdatedAt = recipe.PublishedAt
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recipes = append(s.recipes, copyRecipe(*recipe))
+	return nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id string, recipe *models.Recipe) error {
+	rID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	recipe.ID = rID
+	recipe.UpdatedAt = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(rID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	updated := copyRecipe(*recipe)
+	stored := &s.recipes[i]
+	stored.Name = updated.Name
+	stored.Tags = updated.Tags
+	stored.Ingredients = updated.Ingredients
+	stored.Instructions = updated.Instructions
+	stored.Servings = updated.Servings
+	stored.UpdatedBy = updated.UpdatedBy
+	stored.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	rID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(rID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.recipes = append(s.recipes[:i], s.recipes[i+1:]...)
+	return nil
+}
+
+// index returns the position of the recipe with the given ID or -1.
+// The caller must hold s.mu.
+func (s *MemoryStore) index(id primitive.ObjectID) int {
+	for i, recipe := range s.recipes {
+		if recipe.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyRecipe returns a deep copy so that callers cannot modify stored recipes.
+func copyRecipe(recipe models.Recipe) models.Recipe {
+	recipe.Tags = copyStrings(recipe.Tags)
+	if recipe.Ingredients != nil {
+		recipe.Ingredients = append([]ingredient.Ingredient(nil), recipe.Ingredients...)
+	}
+	recipe.Instructions = copyStrings(recipe.Instructions)
+	return recipe
+}
+
+func copyStrings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	out := make([]string, len(in))
+	copy(out, in)
+	return out
+}
@@ -0,0 +1,260 @@
+package store
+
+import (
+	"context"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/tagquery"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexNotFound is the code of the error MongoDB returns when dropping an
+// index that does not exist.
+const indexNotFound = 27
+
+// MongoStore is a RecipeStore backed by a MongoDB collection.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the indexes used by ListByAuthor, ListPage and
+// SearchText.
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	// A collection has at most one text index, so the one indexing the
+	// ingredients as plain lines has to go first.
+	_, err := s.collection.Indexes().DropOne(ctx, "recipes_text")
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == indexNotFound) {
+		return err
+	}
+	_, err = s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdBy", Value: 1}}},
+		{Keys: bson.D{{Key: SortPublishedAt, Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: SortName, Value: 1}, {Key: "_id", Value: 1}}},
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "ingredients.original", Value: "text"},
+				{Key: "instructions", Value: "text"},
+			},
+			Options: options.Index().SetName("recipes_text_v2").SetWeights(bson.M{
+				"name":                 nameWeight,
+				"ingredients.original": ingredientsWeight,
+				"instructions":         instructionsWeight,
+			}),
+		},
+	})
+	return err
+}
+
+func (s *MongoStore) List(ctx context.Context) ([]models.Recipe, error) {
+	return s.find(ctx, bson.M{})
+}
+
+func (s *MongoStore) ListPage(ctx context.Context, query PageQuery) (Page, error) {
+	total, err := s.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return Page{}, err
+	}
+	direction, op := 1, "$gt"
+	if query.Desc {
+		direction, op = -1, "$lt"
+	}
+	filter := bson.M{}
+	if query.After != nil {
+		value := query.After.value(query.SortBy)
+		filter = bson.M{"$or": bson.A{
+			bson.M{query.SortBy: bson.M{op: value}},
+			bson.M{query.SortBy: value, "_id": bson.M{op: query.After.ID}},
+		}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: query.SortBy, Value: direction}, {Key: "_id", Value: direction}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit) + 1)
+	}
+	recipes, err := s.find(ctx, filter, opts)
+	if err != nil {
+		return Page{}, err
+	}
+	return newPage(recipes, total, query), nil
+}
+
+func (s *MongoStore) ListByAuthor(ctx context.Context, username string) ([]models.Recipe, error) {
+	return s.find(ctx, bson.M{"createdBy": username})
+}
+
+func (s *MongoStore) Get(ctx context.Context, id string) (models.Recipe, error) {
+	var recipe models.Recipe
+	rID, err := parseID(id)
+	if err != nil {
+		return recipe, err
+	}
+	err = s.collection.FindOne(ctx, bson.M{"_id": rID}).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return recipe, ErrNotFound
+	}
+	return recipe, err
+}
+
+func (s *MongoStore) Search(ctx context.Context, query tagquery.Expr) ([]models.Recipe, error) {
+	filter, err := TagFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.find(ctx, filter)
+}
+
+// TagFilter translates a tag query into a MongoDB filter. NOT becomes $nor,
+// as $not only applies to operator expressions on a single field.
+func TagFilter(query tagquery.Expr) (bson.M, error) {
+	switch q := query.(type) {
+	case tagquery.Tag:
+		return bson.M{"tags": q.Name}, nil
+	case tagquery.And:
+		operands, err := tagFilters(q.Operands)
+		return bson.M{"$and": operands}, err
+	case tagquery.Or:
+		operands, err := tagFilters(q.Operands)
+		return bson.M{"$or": operands}, err
+	case tagquery.Not:
+		operand, err := TagFilter(q.Operand)
+		return bson.M{"$nor": bson.A{operand}}, err
+	}
+	return nil, errors.Errorf("unsupported tag query %T", query)
+}
+
+func tagFilters(queries []tagquery.Expr) (bson.A, error) {
+	filters := make(bson.A, 0, len(queries))
+	for _, query := range queries {
+		filter, err := TagFilter(query)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func (s *MongoStore) SearchText(ctx context.Context, query TextQuery, limit int) ([]TextMatch, error) {
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().SetProjection(bson.M{"score": score}).SetSort(bson.M{"score": score})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := s.collection.Find(ctx, bson.M{"$text": bson.M{"$search": query.Raw}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	matches := make([]TextMatch, 0)
+	for cur.Next(ctx) {
+		var match TextMatch
+		if err := cur.Decode(&match); err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, cur.Err()
+}
+
+func (s *MongoStore) Create(ctx context.Context, recipe *models.Recipe) error {
+	recipe.ID = primitive.NewObjectID()
+	recipe.PublishedAt = time.Now()
+	recipe.UpdatedAt = recipe.PublishedAt
+	_, err := s.collection.InsertOne(ctx, recipe)
+	return err
+}
+
+func (s *MongoStore) Update(ctx context.Context, id string, recipe *models.Recipe) error {
+	rID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	recipe.ID = rID
+	recipe.UpdatedAt = time.Now()
+	res, err := s.collection.UpdateOne(ctx, bson.M{"_id": rID}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: recipe.Name},
+		{Key: "tags", Value: recipe.Tags},
+		{Key: "ingredients", Value: recipe.Ingredients},
+		{Key: "instructions", Value: recipe.Instructions},
+		{Key: "servings", Value: recipe.Servings},
+		{Key: "updatedBy", Value: recipe.UpdatedBy},
+		{Key: "updatedAt", Value: recipe.UpdatedAt},
+	}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoStore) Delete(ctx context.Context, id string) error {
+	rID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.collection.DeleteOne(ctx, bson.M{"_id": rID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// BackfillIngredients parses the ingredients of recipes stored as plain
+// lines before ingredients were parsed and returns the number of updated
+// recipes.
+func (s *MongoStore) BackfillIngredients(ctx context.Context) (int, error) {
+	recipes, err := s.find(ctx, bson.M{"ingredients": bson.M{"$type": "string"}})
+	if err != nil {
+		return 0, errors.Wrap(err, "While finding unparsed ingredients")
+	}
+	for i, recipe := range recipes {
+		_, err := s.collection.UpdateOne(ctx, bson.M{"_id": recipe.ID}, bson.M{"$set": bson.M{"ingredients": recipe.Ingredients}})
+		if err != nil {
+			return i, errors.Wrapf(err, "While parsing ingredients of recipe %s", recipe.ID.Hex())
+		}
+	}
+	return len(recipes), nil
+}
+
+func (s *MongoStore) find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]models.Recipe, error) {
+	cur, err := s.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	recipes := make([]models.Recipe, 0)
+	for cur.Next(ctx) {
+		var recipe models.Recipe
+		if err := cur.Decode(&recipe); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, cur.Err()
+}
+
+// parseID converts the hex representation of a recipe ID into an ObjectID.
+func parseID(id string) (primitive.ObjectID, error) {
+	rID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return rID, errors.Wrap(ErrInvalidID, err.Error())
+	}
+	return rID, nil
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"local/gin/gin-recipes-api/ingredient"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/tagquery"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// forEachRecipeStore runs test against an empty store of every backend
+// that works without a server, so that they are held to the same behavior.
+func forEachRecipeStore(t *testing.T, test func(t *testing.T, s RecipeStore)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryStore(nil)) })
+	t.Run("sql", func(t *testing.T) { test(t, newTestSQLStore(t)) })
+}
+
+// createRecipes creates a recipe for every name, by author, with the tags
+// and ingredients derived from the name.
+func createRecipes(t *testing.T, s RecipeStore, author string, names ...string) []models.Recipe {
+	t.Helper()
+	recipes := make([]models.Recipe, len(names))
+	for i, name := range names {
+		recipes[i] = models.Recipe{
+			Name:         name,
+			Tags:         strings.Fields(strings.ToLower(name)),
+			Ingredients:  []ingredient.Ingredient{ingredient.Parse("2 cups " + strings.ToLower(name))},
+			Instructions: []string{"Cook the " + strings.ToLower(name) + "."},
+			CreatedBy:    author,
+		}
+		if err := s.Create(context.Background(), &recipes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recipes
+}
+
+func names(recipes []models.Recipe) []string {
+	list := make([]string, len(recipes))
+	for i, recipe := range recipes {
+		list[i] = recipe.Name
+	}
+	return list
+}
+
+func sortedNames(recipes []models.Recipe) string {
+	list := names(recipes)
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func TestRecipeStoreCRUD(t *testing.T) {
+	forEachRecipeStore(t, func(t *testing.T, s RecipeStore) {
+		ctx := context.Background()
+		recipe := models.Recipe{
+			Name:         "Tomato soup",
+			Tags:         []string{"soup", "vegetarian"},
+			Ingredients:  []ingredient.Ingredient{ingredient.Parse("1 1/2 cups tomatoes, diced"), ingredient.Parse("salt")},
+			Instructions: []string{"Simmer.", "Blend."},
+			Servings:     4,
+			CreatedBy:    "alice",
+		}
+		if err := s.Create(ctx, &recipe); err != nil {
+			t.Fatal(err)
+		}
+		if recipe.ID.IsZero() || recipe.PublishedAt.IsZero() || !recipe.UpdatedAt.Equal(recipe.PublishedAt) {
+			t.Fatalf("Create() set ID %v, PublishedAt %v, UpdatedAt %v", recipe.ID, recipe.PublishedAt, recipe.UpdatedAt)
+		}
+		got, err := s.Get(ctx, recipe.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != recipe.Name || !reflect.DeepEqual(got.Tags, recipe.Tags) ||
+			!reflect.DeepEqual(got.Ingredients, recipe.Ingredients) ||
+			!reflect.DeepEqual(got.Instructions, recipe.Instructions) ||
+			got.Servings != 4 || got.CreatedBy != "alice" || !got.PublishedAt.Equal(recipe.PublishedAt) {
+			t.Errorf("Get() = %+v, want %+v", got, recipe)
+		}
+
+		update := models.Recipe{
+			Name:         "Creamy tomato soup",
+			Tags:         []string{"soup"},
+			Ingredients:  []ingredient.Ingredient{ingredient.Parse("1 cup cream")},
+			Instructions: []string{"Stir in the cream."},
+			UpdatedBy:    "bob",
+		}
+		if err := s.Update(ctx, recipe.ID.Hex(), &update); err != nil {
+			t.Fatal(err)
+		}
+		got, err = s.Get(ctx, recipe.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != update.Name || !reflect.DeepEqual(got.Tags, update.Tags) ||
+			!reflect.DeepEqual(got.Ingredients, update.Ingredients) || got.Servings != 0 ||
+			got.UpdatedBy != "bob" || got.CreatedBy != "alice" ||
+			!got.PublishedAt.Equal(recipe.PublishedAt) || got.UpdatedAt.Before(recipe.UpdatedAt) {
+			t.Errorf("Get() after Update() = %+v", got)
+		}
+
+		if err := s.Delete(ctx, recipe.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		missing := primitive.NewObjectID().Hex()
+		for name, err := range map[string]error{
+			"Get(deleted)":    func() error { _, err := s.Get(ctx, recipe.ID.Hex()); return err }(),
+			"Delete(deleted)": s.Delete(ctx, recipe.ID.Hex()),
+			"Update(missing)": s.Update(ctx, missing, &update),
+		} {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s = %v, want ErrNotFound", name, err)
+			}
+		}
+		if _, err := s.Get(ctx, "not-an-id"); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Get(invalid ID) = %v, want ErrInvalidID", err)
+		}
+		if err := s.Delete(ctx, "not-an-id"); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Delete(invalid ID) = %v, want ErrInvalidID", err)
+		}
+	})
+}
+
+func TestRecipeStoreList(t *testing.T) {
+	forEachRecipeStore(t, func(t *testing.T, s RecipeStore) {
+		ctx := context.Background()
+		createRecipes(t, s, "alice", "Pumpkin pie", "Apple pie")
+		createRecipes(t, s, "bob", "Bean stew", "Cherry cake", "Dumplings")
+
+		all, err := s.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sortedNames(all); got != "Apple pie,Bean stew,Cherry cake,Dumplings,Pumpkin pie" {
+			t.Errorf("List() = %s", got)
+		}
+		mine, err := s.ListByAuthor(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sortedNames(mine); got != "Apple pie,Pumpkin pie" {
+			t.Errorf("ListByAuthor(alice) = %s", got)
+		}
+
+		page, err := s.ListPage(ctx, PageQuery{SortBy: SortName, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(names(page.Recipes), ","); got != "Apple pie,Bean stew" || page.Total != 5 || page.Next == nil {
+			t.Errorf("first page = %s, total %d, next %v", got, page.Total, page.Next)
+		}
+		if got := strings.Join(walk(t, s, PageQuery{SortBy: SortName, Desc: true, Limit: 2}), ","); got != "Pumpkin pie,Dumplings,Cherry cake,Bean stew,Apple pie" {
+			t.Errorf("descending pages = %s", got)
+		}
+		if got := walk(t, s, PageQuery{SortBy: SortPublishedAt, Limit: 3}); len(got) != 5 {
+			t.Errorf("pages by publication = %v, want all 5 recipes", got)
+		}
+	})
+}
+
+func TestRecipeStoreSearch(t *testing.T) {
+	forEachRecipeStore(t, func(t *testing.T, s RecipeStore) {
+		ctx := context.Background()
+		createRecipes(t, s, "alice", "Pumpkin pie", "Apple pie", "Apple cake", "Bean stew")
+		for query, want := range map[string]string{
+			"pie":               "Apple pie,Pumpkin pie",
+			"apple AND pie":     "Apple pie",
+			"cake OR stew":      "Apple cake,Bean stew",
+			"pie AND NOT apple": "Pumpkin pie",
+			"soup":              "",
+		} {
+			expr, err := tagquery.Parse(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recipes, err := s.Search(ctx, expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortedNames(recipes); got != want {
+				t.Errorf("Search(%s) = %s, want %s", query, got, want)
+			}
+		}
+
+		matches, err := s.SearchText(ctx, ParseTextQuery("apples -cake"), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 || matches[0].Name != "Apple pie" || matches[0].Score <= 0 {
+			t.Errorf("SearchText(apples -cake) = %+v, want Apple pie", matches)
+		}
+	})
+}
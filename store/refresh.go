@@ -0,0 +1,167 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+var (
+	// ErrInvalidToken is returned for unknown, expired or revoked refresh tokens.
+	ErrInvalidToken = errors.New("invalid refresh token")
+	// ErrTokenReused is returned when an already rotated refresh token is
+	// presented again. The whole token family is revoked in that case.
+	ErrTokenReused = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenStore manages opaque, one-time-use refresh tokens in Redis.
+//
+// Every sign-in starts a token family. Rotating a token marks it as used
+// and issues the next token of the same family. Presenting a used token
+// again revokes the family, logging out both the legitimate client and
+// whoever stole the token. The keys are
+//
+//	refresh:<sha256 of token>   hash with username, family and used-at
+//	refresh_family:<family>     username, present while the family is active
+//	refresh_user:<username>     set of the user's families
+type RefreshTokenStore struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+}
+
+func NewRefreshTokenStore(redisClient *redis.Client, ttl time.Duration) *RefreshTokenStore {
+	return &RefreshTokenStore{
+		redisClient: redisClient,
+		ttl:         ttl,
+	}
+}
+
+// Issue starts a new token family for username and returns its first token.
+func (s *RefreshTokenStore) Issue(username string) (token, family string, err error) {
+	family = xid.New().String()
+	_, err = s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(familyKey(family), username, s.ttl)
+		pipe.SAdd(userFamiliesKey(username), family)
+		pipe.Expire(userFamiliesKey(username), s.ttl)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	token, err = s.issue(username, family)
+	return token, family, err
+}
+
+// Rotate consumes token and returns the next token of its family.
+func (s *RefreshTokenStore) Rotate(token string) (username, family, next string, err error) {
+	key := tokenKey(token)
+	record, err := s.redisClient.HGetAll(key).Result()
+	if err != nil {
+		return "", "", "", err
+	}
+	username, family = record["username"], record["family"]
+	if username == "" || family == "" {
+		return "", "", "", ErrInvalidToken
+	}
+	first, err := s.redisClient.HSetNX(key, "used", time.Now().Unix()).Result()
+	if err != nil {
+		return "", "", "", err
+	}
+	if !first {
+		if err := s.RevokeFamily(username, family); err != nil {
+			return "", "", "", err
+		}
+		return username, family, "", ErrTokenReused
+	}
+	active, err := s.FamilyActive(family)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !active {
+		return "", "", "", ErrInvalidToken
+	}
+	// The user's set of families has to live as long as any of them, or
+	// RevokeUser would miss the families kept alive by rotation.
+	_, err = s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Expire(familyKey(family), s.ttl)
+		pipe.SAdd(userFamiliesKey(username), family)
+		pipe.Expire(userFamiliesKey(username), s.ttl)
+		return nil
+	})
+	if err != nil {
+		return "", "", "", err
+	}
+	next, err = s.issue(username, family)
+	return username, family, next, err
+}
+
+// FamilyActive reports whether the token family has not been revoked or expired.
+func (s *RefreshTokenStore) FamilyActive(family string) (bool, error) {
+	n, err := s.redisClient.Exists(familyKey(family)).Result()
+	return n == 1, err
+}
+
+// RevokeFamily invalidates all tokens of a family.
+func (s *RefreshTokenStore) RevokeFamily(username, family string) error {
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(familyKey(family))
+		pipe.SRem(userFamiliesKey(username), family)
+		return nil
+	})
+	return err
+}
+
+// RevokeUser invalidates all token families of username.
+func (s *RefreshTokenStore) RevokeUser(username string) error {
+	families, err := s.redisClient.SMembers(userFamiliesKey(username)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userFamiliesKey(username)}
+	for _, family := range families {
+		keys = append(keys, familyKey(family))
+	}
+	return s.redisClient.Del(keys...).Err()
+}
+
+func (s *RefreshTokenStore) issue(username, family string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "While generating refresh token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(tokenKey(token), map[string]interface{}{
+			"username": username,
+			"family":   family,
+		})
+		pipe.Expire(tokenKey(token), s.ttl)
+		return nil
+	})
+	return token, err
+}
+
+// tokenKey stores only a digest of the token, so a dump of Redis does not
+// reveal usable tokens.
+func tokenKey(token string) string {
+	return "refresh:" + digest(token)
+}
+
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func familyKey(family string) string {
+	return "refresh_family:" + family
+}
+
+func userFamiliesKey(username string) string {
+	return "refresh_user:" + username
+}
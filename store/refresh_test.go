@@ -0,0 +1,115 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	client, _ := newTestRedis(t)
+	s := NewRefreshTokenStore(client, time.Hour)
+	first, family, err := s.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, rotatedFamily, second, err := s.Rotate(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" || rotatedFamily != family || second == "" || second == first {
+		t.Fatalf("Rotate() = %q, %q, %q, want alice, %q and a new token", username, rotatedFamily, second, family)
+	}
+	if _, _, third, err := s.Rotate(second); err != nil || third == "" {
+		t.Fatalf("Rotate(second token) = %q, %v", third, err)
+	}
+	if _, _, _, err := s.Rotate("unknown"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Rotate(unknown token) = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	client, _ := newTestRedis(t)
+	s := NewRefreshTokenStore(client, time.Hour)
+	first, family, err := s.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherFamily, err := s.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, second, err := s.Rotate(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := s.Rotate(first); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("Rotate(used token) = %v, want ErrTokenReused", err)
+	}
+	if active, _ := s.FamilyActive(family); active {
+		t.Errorf("family is still active after reuse")
+	}
+	// the token issued to the legitimate client dies with the family
+	if _, _, _, err := s.Rotate(second); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Rotate(token of revoked family) = %v, want ErrInvalidToken", err)
+	}
+	if active, _ := s.FamilyActive(otherFamily); !active {
+		t.Errorf("reuse revoked another sign-in of the user")
+	}
+}
+
+func TestRefreshTokenExpiry(t *testing.T) {
+	client, mr := newTestRedis(t)
+	s := NewRefreshTokenStore(client, time.Hour)
+	token, family, err := s.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotation keeps the family and the user's set of families alive
+	for i := 0; i < 3; i++ {
+		mr.FastForward(50 * time.Minute)
+		if _, _, token, err = s.Rotate(token); err != nil {
+			t.Fatalf("rotation %d: %v", i, err)
+		}
+	}
+	if ttl := mr.TTL(userFamiliesKey("alice")); ttl < 50*time.Minute {
+		t.Errorf("TTL of the user's families = %v, want it renewed by rotation", ttl)
+	}
+	if err := s.RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := s.FamilyActive(family); active {
+		t.Errorf("RevokeUser missed a family kept alive by rotation")
+	}
+
+	token, _, err = s.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Hour)
+	if _, _, _, err := s.Rotate(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Rotate(expired token) = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRefreshTokenRevokeUser(t *testing.T) {
+	client, _ := newTestRedis(t)
+	s := NewRefreshTokenStore(client, time.Hour)
+	var tokens []string
+	for _, username := range []string{"alice", "alice", "bob"} {
+		token, _, err := s.Issue(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := s.RevokeUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []error{ErrInvalidToken, ErrInvalidToken, nil} {
+		if _, _, _, err := s.Rotate(tokens[i]); !errors.Is(err, want) {
+			t.Errorf("Rotate(token %d) = %v, want %v", i, err, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidResetToken is returned for unknown, expired or used password reset tokens.
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordResetStore manages single-use password reset tokens in Redis.
+// Issuing a token invalidates the previous token of the user.
+//
+// Requests for tokens are limited to one per username every interval and
+// to ipLimit per client IP; the counter of an IP is forgotten a token
+// lifetime after its last request. The keys are
+//
+//	password_reset:<sha256 of token>   username
+//	password_reset_user:<username>     digest of the current token
+//	password_reset_sent:<username>     present during interval after a request
+//	password_reset_ip:<ip>             requests from the IP
+type PasswordResetStore struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+	interval    time.Duration
+	ipLimit     int64
+}
+
+func NewPasswordResetStore(redisClient *redis.Client, ttl, interval time.Duration, ipLimit int) *PasswordResetStore {
+	return &PasswordResetStore{
+		redisClient: redisClient,
+		ttl:         ttl,
+		interval:    interval,
+		ipLimit:     int64(ipLimit),
+	}
+}
+
+// Allow records a request for a token for username from ip and returns how
+// long the request has to wait, or zero if a token may be sent.
+func (s *PasswordResetStore) Allow(username, ip string) (time.Duration, error) {
+	var addr *redis.IntCmd
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		addr = pipe.Incr(resetIPKey(ip))
+		pipe.Expire(resetIPKey(ip), s.ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if addr.Val() > s.ipLimit {
+		return s.ttl, nil
+	}
+	sent, err := s.redisClient.SetNX(resetSentKey(username), ip, s.interval).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !sent {
+		wait, err := s.redisClient.PTTL(resetSentKey(username)).Result()
+		if err != nil {
+			return 0, err
+		}
+		return maxDuration(wait, time.Second), nil
+	}
+	return 0, nil
+}
+
+// Issue returns a new reset token for username.
+func (s *PasswordResetStore) Issue(username string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "While generating reset token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	var previous *redis.StringCmd
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(resetKey(token), username, s.ttl)
+		previous = pipe.GetSet(userResetKey(username), digest(token))
+		pipe.Expire(userResetKey(username), s.ttl)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	if previous.Val() != "" {
+		if err := s.redisClient.Del("password_reset:" + previous.Val()).Err(); err != nil {
+			return "", err
+		}
+	}
+	return token, nil
+}
+
+// Lookup returns the username token was issued for without using it up.
+func (s *PasswordResetStore) Lookup(token string) (string, error) {
+	username, err := s.redisClient.Get(resetKey(token)).Result()
+	if err == redis.Nil {
+		return "", ErrInvalidResetToken
+	}
+	return username, err
+}
+
+// Consume invalidates token and returns the username it was issued for.
+// Of concurrent calls with the same token only one succeeds.
+func (s *PasswordResetStore) Consume(token string) (string, error) {
+	var get *redis.StringCmd
+	var del *redis.IntCmd
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(resetKey(token))
+		del = pipe.Del(resetKey(token))
+		return nil
+	})
+	if err == redis.Nil || del.Val() == 0 {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", err
+	}
+	username := get.Val()
+	return username, s.redisClient.Del(userResetKey(username)).Err()
+}
+
+// Revoke invalidates the outstanding token of username, if any.
+func (s *PasswordResetStore) Revoke(username string) error {
+	var get *redis.StringCmd
+	_, err := s.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(userResetKey(username))
+		pipe.Del(userResetKey(username))
+		return nil
+	})
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.redisClient.Del("password_reset:" + get.Val()).Err()
+}
+
+func resetKey(token string) string {
+	return "password_reset:" + digest(token)
+}
+
+func userResetKey(username string) string {
+	return "password_reset_user:" + username
+}
+
+func resetSentKey(username string) string {
+	return "password_reset_sent:" + username
+}
+
+func resetIPKey(ip string) string {
+	return "password_reset_ip:" + ip
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seedRecipe accepts any string as ID, since the IDs in recipes.json are
+// not valid ObjectIDs.
+type seedRecipe struct {
+	models.Recipe
+	ID string `json:"id"`
+}
+
+// LoadRecipes reads recipes from a JSON file. Recipes without a valid
+// ObjectID get a new one.
+func LoadRecipes(path string) ([]models.Recipe, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seeds := make([]seedRecipe, 0)
+	if err := json.Unmarshal(file, &seeds); err != nil {
+		return nil, errors.Wrapf(err, "While parsing %s", path)
+	}
+	recipes := make([]models.Recipe, 0, len(seeds))
+	for _, seed := range seeds {
+		recipe := seed.Recipe
+		recipe.ID, err = primitive.ObjectIDFromHex(seed.ID)
+		if err != nil {
+			recipe.ID = primitive.NewObjectID()
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, nil
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"local/gin/gin-recipes-api/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// ErrSessionNotFound is returned for unknown, expired or revoked sessions.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRegistry keeps track of the cookie sessions of each user in Redis,
+// so that they can be listed and revoked. The session cookie only carries
+// the session ID; a session whose ID is not registered is not accepted.
+// Sessions expire ttl after they were last seen. The keys are
+//
+//	user_session:<id>          hash with username, user agent, IP and timestamps
+//	user_sessions:<username>   set of the user's session IDs
+type SessionRegistry struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+}
+
+func NewSessionRegistry(redisClient *redis.Client, ttl time.Duration) *SessionRegistry {
+	return &SessionRegistry{
+		redisClient: redisClient,
+		ttl:         ttl,
+	}
+}
+
+// Create registers a new session of username.
+func (r *SessionRegistry) Create(username, userAgent, ip string) (models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         xid.New().String(),
+		Username:   username,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	_, err := r.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(sessionKey(session.ID), map[string]interface{}{
+			"username":   username,
+			"userAgent":  userAgent,
+			"ip":         ip,
+			"createdAt":  now.Unix(),
+			"lastSeenAt": now.Unix(),
+		})
+		pipe.Expire(sessionKey(session.ID), r.ttl)
+		pipe.SAdd(userSessionsKey(username), session.ID)
+		pipe.Expire(userSessionsKey(username), r.ttl)
+		return nil
+	})
+	return session, err
+}
+
+// Touch records that the session was used from ip and returns its user.
+func (r *SessionRegistry) Touch(id, ip string) (string, error) {
+	username, err := r.redisClient.HGet(sessionKey(id), "username").Result()
+	if err == redis.Nil {
+		return "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = r.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(sessionKey(id), map[string]interface{}{
+			"ip":         ip,
+			"lastSeenAt": time.Now().Unix(),
+		})
+		pipe.Expire(sessionKey(id), r.ttl)
+		pipe.Expire(userSessionsKey(username), r.ttl)
+		return nil
+	})
+	return username, err
+}
+
+// List returns the active sessions of username, most recently seen first.
+func (r *SessionRegistry) List(username string) ([]models.Session, error) {
+	ids, err := r.redisClient.SMembers(userSessionsKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	_, err = r.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(sessionKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]models.Session, 0, len(ids))
+	var expired []interface{}
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if fields["username"] == "" {
+			expired = append(expired, ids[i])
+			continue
+		}
+		sessions = append(sessions, models.Session{
+			ID:         ids[i],
+			Username:   fields["username"],
+			UserAgent:  fields["userAgent"],
+			IP:         fields["ip"],
+			CreatedAt:  unixField(fields["createdAt"]),
+			LastSeenAt: unixField(fields["lastSeenAt"]),
+		})
+	}
+	if len(expired) != 0 {
+		if err := r.redisClient.SRem(userSessionsKey(username), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// Revoke ends the session with the given ID if it belongs to username.
+func (r *SessionRegistry) Revoke(username, id string) error {
+	owner, err := r.redisClient.HGet(sessionKey(id), "username").Result()
+	if err == redis.Nil || (err == nil && owner != username) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(sessionKey(id))
+		pipe.SRem(userSessionsKey(username), id)
+		return nil
+	})
+	return err
+}
+
+// RevokeUser ends all sessions of username except the one with the ID
+// keep, which may be empty.
+func (r *SessionRegistry) RevokeUser(username, keep string) error {
+	ids, err := r.redisClient.SMembers(userSessionsKey(username)).Result()
+	if err != nil {
+		return err
+	}
+	_, err = r.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			if id != keep {
+				pipe.Del(sessionKey(id))
+				pipe.SRem(userSessionsKey(username), id)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func unixField(value string) time.Time {
+	seconds, _ := strconv.ParseInt(value, 10, 64)
+	return time.Unix(seconds, 0)
+}
+
+func sessionKey(id string) string {
+	return "user_session:" + id
+}
+
+func userSessionsKey(username string) string {
+	return "user_sessions:" + username
+}
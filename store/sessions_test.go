@@ -0,0 +1,108 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	client, _ := newTestRedis(t)
+	r := NewSessionRegistry(client, time.Hour)
+	first, err := r.Create("alice", "curl/7.79", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Create("alice", "Firefox", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Create("bob", "Firefox", "10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if username, err := r.Touch(first.ID, "10.0.0.4"); username != "alice" || err != nil {
+		t.Fatalf("Touch() = %q, %v, want alice", username, err)
+	}
+	list, err := r.List("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("List() returned %d sessions, want 2", len(list))
+	}
+	for _, session := range list {
+		if session.ID == first.ID && session.IP != "10.0.0.4" {
+			t.Errorf("IP after Touch() = %q, want 10.0.0.4", session.IP)
+		}
+	}
+
+	if err := r.Revoke("bob", first.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Revoke(session of another user) = %v, want ErrSessionNotFound", err)
+	}
+	if err := r.Revoke("alice", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Touch(first.ID, "10.0.0.1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Touch(revoked session) = %v, want ErrSessionNotFound", err)
+	}
+	if err := r.Revoke("alice", first.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Revoke(revoked session) = %v, want ErrSessionNotFound", err)
+	}
+	if list, _ := r.List("alice"); len(list) != 1 || list[0].ID != second.ID {
+		t.Errorf("List() after Revoke() = %+v, want only the second session", list)
+	}
+}
+
+func TestSessionRegistryRevokeUser(t *testing.T) {
+	client, _ := newTestRedis(t)
+	r := NewSessionRegistry(client, time.Hour)
+	var ids []string
+	for _, username := range []string{"alice", "alice", "alice", "bob"} {
+		session, err := r.Create(username, "Firefox", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+	}
+	if err := r.RevokeUser("alice", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []error{ErrSessionNotFound, nil, ErrSessionNotFound, nil} {
+		if _, err := r.Touch(ids[i], "10.0.0.1"); !errors.Is(err, want) {
+			t.Errorf("Touch(session %d) = %v, want %v", i, err, want)
+		}
+	}
+	if err := r.RevokeUser("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := r.List("alice"); len(list) != 0 {
+		t.Errorf("List() after RevokeUser() = %+v, want none", list)
+	}
+}
+
+func TestSessionRegistryExpiry(t *testing.T) {
+	client, mr := newTestRedis(t)
+	r := NewSessionRegistry(client, time.Hour)
+	active, err := r.Create("alice", "Firefox", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := r.Create("alice", "curl/7.79", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// using a session extends it, the idle one expires
+	mr.FastForward(50 * time.Minute)
+	if _, err := r.Touch(active.ID, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(50 * time.Minute)
+	if _, err := r.Touch(idle.ID, "10.0.0.1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Touch(idle session) = %v, want ErrSessionNotFound", err)
+	}
+	if list, _ := r.List("alice"); len(list) != 1 || list[0].ID != active.ID {
+		t.Errorf("List() = %+v, want only the active session", list)
+	}
+}
@@ -0,0 +1,876 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"local/gin/gin-recipes-api/ingredient"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/tagquery"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// migrations holds the schema changes of the SQL store. Entries are applied
+// in order and must never be modified once released; append new ones instead.
+var migrations = []string{
+	`CREATE TABLE recipes (
+		id           VARCHAR(24) PRIMARY KEY,
+		name         TEXT NOT NULL,
+		published_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE recipe_tags (
+		recipe_id VARCHAR(24) NOT NULL REFERENCES recipes(id),
+		position  INTEGER NOT NULL,
+		tag       TEXT NOT NULL,
+		PRIMARY KEY (recipe_id, position)
+	)`,
+	`CREATE INDEX recipe_tags_tag ON recipe_tags (tag)`,
+	`CREATE TABLE recipe_ingredients (
+		recipe_id  VARCHAR(24) NOT NULL REFERENCES recipes(id),
+		position   INTEGER NOT NULL,
+		ingredient TEXT NOT NULL,
+		PRIMARY KEY (recipe_id, position)
+	)`,
+	`CREATE TABLE recipe_instructions (
+		recipe_id   VARCHAR(24) NOT NULL REFERENCES recipes(id),
+		position    INTEGER NOT NULL,
+		instruction TEXT NOT NULL,
+		PRIMARY KEY (recipe_id, position)
+	)`,
+	`CREATE TABLE users (
+		username VARCHAR(255) PRIMARY KEY,
+		password TEXT NOT NULL
+	)`,
+	`ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN created_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipes ADD COLUMN created_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipes ADD COLUMN updated_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipes ADD COLUMN updated_at TIMESTAMP`,
+	`CREATE INDEX recipes_created_by ON recipes (created_by)`,
+	`CREATE TABLE api_keys (
+		id           VARCHAR(20) PRIMARY KEY,
+		username     VARCHAR(255) NOT NULL REFERENCES users(username),
+		name         TEXT NOT NULL,
+		hash         VARCHAR(64) NOT NULL UNIQUE,
+		scopes       TEXT NOT NULL,
+		created_at   TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP
+	)`,
+	`CREATE INDEX api_keys_username ON api_keys (username)`,
+	`ALTER TABLE users ADD COLUMN subject TEXT NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX users_subject ON users (subject) WHERE subject <> ''`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN totp_recovery_codes TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX recipes_published_at ON recipes (published_at, id)`,
+	`CREATE INDEX recipes_name ON recipes (name, id)`,
+	`ALTER TABLE recipe_ingredients ADD COLUMN quantity TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipe_ingredients ADD COLUMN quantity_max TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipe_ingredients ADD COLUMN unit TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipe_ingredients ADD COLUMN note TEXT NOT NULL DEFAULT ''`,
+	// item stays NULL for rows written before ingredients were parsed, until
+	// BackfillIngredients parses them.
+	`ALTER TABLE recipe_ingredients ADD COLUMN item TEXT`,
+	`ALTER TABLE recipes ADD COLUMN servings INTEGER NOT NULL DEFAULT 0`,
+	// users signed up before roles existed keep the permissions of authors
+	`UPDATE users SET role = 'author' WHERE role = ''`,
+	`-- normalize timestamps to UTC, see normalizeTimes`,
+}
+
+// dataMigrations holds the migrations that are written in Go, keyed by
+// their version. Their entry in migrations is a comment.
+var dataMigrations = map[int]func(ctx context.Context, s *SQLStore, tx *sql.Tx) error{
+	31: normalizeTimes,
+}
+
+// timeColumns lists the timestamp columns of every table by primary key.
+var timeColumns = []struct {
+	table, key string
+	columns    []string
+}{
+	{"recipes", "id", []string{"published_at", "updated_at"}},
+	{"users", "username", []string{"created_at"}},
+	{"api_keys", "id", []string{"created_at", "expires_at", "last_used_at"}},
+}
+
+// normalizeTimes rewrites all timestamps in UTC. They used to be written in
+// the zone they were created in: SQLite keeps the offset in the text, which
+// then sorts wrongly, and PostgreSQL drops it from TIMESTAMP columns, so
+// those values are taken to be in the local zone of the server.
+func normalizeTimes(ctx context.Context, s *SQLStore, tx *sql.Tx) error {
+	for _, t := range timeColumns {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, %s FROM %s", t.key, strings.Join(t.columns, ", "), t.table))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		var values [][]interface{}
+		for rows.Next() {
+			var key string
+			times := make([]sql.NullTime, len(t.columns))
+			dest := []interface{}{&key}
+			for i := range times {
+				dest = append(dest, &times[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return err
+			}
+			row := make([]interface{}, len(times))
+			for i, value := range times {
+				if value.Valid {
+					if s.driver == "postgres" {
+						value.Time = reinterpretLocal(value.Time)
+					}
+					value.Time = value.Time.UTC()
+				}
+				row[i] = value
+			}
+			keys = append(keys, key)
+			values = append(values, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		assignments := make([]string, len(t.columns))
+		for i, column := range t.columns {
+			assignments[i] = column + " = ?"
+		}
+		stmt := s.rebind(fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", t.table, strings.Join(assignments, ", "), t.key))
+		for i, key := range keys {
+			if _, err := tx.ExecContext(ctx, stmt, append(values[i], key)...); err != nil {
+				return errors.Wrapf(err, "While normalizing %s %s", t.table, key)
+			}
+		}
+	}
+	return nil
+}
+
+// reinterpretLocal returns the instant the wall clock of t shows in the
+// local zone.
+func reinterpretLocal(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// SQLStore is a RecipeStore, UserStore and APIKeyStore backed by a SQL database.
+// SQLite ("sqlite3") and PostgreSQL ("postgres") are supported.
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLStore returns a SQLStore using db. The driver name selects the
+// placeholder syntax of the SQL dialect.
+func NewSQLStore(db *sql.DB, driver string) (*SQLStore, error) {
+	switch driver {
+	case "sqlite3", "postgres":
+	default:
+		return nil, errors.Errorf("unsupported SQL driver '%s'", driver)
+	}
+	return &SQLStore{
+		db:     db,
+		driver: driver,
+	}, nil
+}
+
+// Migrate brings the schema up to date and returns the number of applied migrations.
+func (s *SQLStore) Migrate(ctx context.Context) (int, error) {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return 0, errors.Wrap(err, "While creating schema_migrations")
+	}
+	var version int
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "While reading schema version")
+	}
+	applied := 0
+	for v := version + 1; v <= len(migrations); v++ {
+		err := s.withTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[v-1]); err != nil {
+				return err
+			}
+			if migrate, ok := dataMigrations[v]; ok {
+				if err := migrate(ctx, s, tx); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), v)
+			return err
+		})
+		if err != nil {
+			return applied, errors.Wrapf(err, "While applying migration %d", v)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// Count returns the number of stored recipes.
+func (s *SQLStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM recipes`).Scan(&count)
+	return count, err
+}
+
+// Insert stores the given recipes as they are, keeping their IDs.
+func (s *SQLStore) Insert(ctx context.Context, recipes []models.Recipe) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, recipe := range recipes {
+			if err := s.insert(ctx, tx, recipe); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLStore) List(ctx context.Context) ([]models.Recipe, error) {
+	return s.find(ctx, "", nil)
+}
+
+func (s *SQLStore) ListPage(ctx context.Context, query PageQuery) (Page, error) {
+	total, err := s.Count(ctx)
+	if err != nil {
+		return Page{}, err
+	}
+	column := "published_at"
+	if query.SortBy == SortName {
+		column = "name"
+	}
+	op, direction := ">", "ASC"
+	if query.Desc {
+		op, direction = "<", "DESC"
+	}
+	where, args := "1 = 1", []interface{}{}
+	if query.After != nil {
+		value := query.After.value(query.SortBy)
+		where = fmt.Sprintf("%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?)", column, op)
+		args = append(args, value, value, query.After.ID.Hex())
+	}
+	stmt := fmt.Sprintf("SELECT id FROM recipes WHERE %s ORDER BY %s %s, id %s", where, column, direction, direction)
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit+1)
+	}
+	ids, err := s.selectIDs(ctx, stmt, args)
+	if err != nil || len(ids) == 0 {
+		return newPage(make([]models.Recipe, 0), total, query), err
+	}
+	recipes, err := s.find(ctx, fmt.Sprintf("id IN (%s)", placeholders(len(ids))), ids)
+	if err != nil {
+		return Page{}, err
+	}
+	position := make(map[string]int, len(ids))
+	for i, id := range ids {
+		position[id.(string)] = i
+	}
+	sort.Slice(recipes, func(i, j int) bool {
+		return position[recipes[i].ID.Hex()] < position[recipes[j].ID.Hex()]
+	})
+	return newPage(recipes, total, query), nil
+}
+
+// selectIDs runs a query returning a single column of recipe IDs.
+func (s *SQLStore) selectIDs(ctx context.Context, query string, args []interface{}) ([]interface{}, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]interface{}, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLStore) ListByAuthor(ctx context.Context, username string) ([]models.Recipe, error) {
+	return s.find(ctx, "created_by = ?", []interface{}{username})
+}
+
+func (s *SQLStore) Get(ctx context.Context, id string) (models.Recipe, error) {
+	if _, err := parseID(id); err != nil {
+		return models.Recipe{}, err
+	}
+	recipes, err := s.find(ctx, "id = ?", []interface{}{id})
+	if err != nil {
+		return models.Recipe{}, err
+	}
+	if len(recipes) == 0 {
+		return models.Recipe{}, ErrNotFound
+	}
+	return recipes[0], nil
+}
+
+func (s *SQLStore) Search(ctx context.Context, query tagquery.Expr) ([]models.Recipe, error) {
+	where, args, err := tagCondition(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.find(ctx, where, args)
+}
+
+// tagCondition translates a tag query into a condition on the recipes table.
+func tagCondition(query tagquery.Expr) (string, []interface{}, error) {
+	var operands []tagquery.Expr
+	var sep string
+	switch q := query.(type) {
+	case tagquery.Tag:
+		return "id IN (SELECT recipe_id FROM recipe_tags WHERE tag = ?)", []interface{}{q.Name}, nil
+	case tagquery.Not:
+		where, args, err := tagCondition(q.Operand)
+		return "NOT (" + where + ")", args, err
+	case tagquery.And:
+		operands, sep = q.Operands, " AND "
+	case tagquery.Or:
+		operands, sep = q.Operands, " OR "
+	default:
+		return "", nil, errors.Errorf("unsupported tag query %T", query)
+	}
+	conditions := make([]string, 0, len(operands))
+	args := make([]interface{}, 0, len(operands))
+	for _, operand := range operands {
+		where, operandArgs, err := tagCondition(operand)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "("+where+")")
+		args = append(args, operandArgs...)
+	}
+	return strings.Join(conditions, sep), args, nil
+}
+
+// SearchText narrows the recipes down to those containing any of the terms
+// with LIKE and ranks the candidates like the MemoryStore does.
+func (s *SQLStore) SearchText(ctx context.Context, query TextQuery, limit int) ([]TextMatch, error) {
+	if len(query.Terms) == 0 {
+		return make([]TextMatch, 0), nil
+	}
+	conditions := make([]string, 0, len(query.Terms))
+	args := make([]interface{}, 0, 3*len(query.Terms))
+	for _, term := range query.Terms {
+		conditions = append(conditions, `(LOWER(name) LIKE ?
+			OR id IN (SELECT recipe_id FROM recipe_ingredients WHERE LOWER(ingredient) LIKE ?)
+			OR id IN (SELECT recipe_id FROM recipe_instructions WHERE LOWER(instruction) LIKE ?))`)
+		pattern := "%" + likeStem(term) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+	recipes, err := s.find(ctx, strings.Join(conditions, " OR "), args)
+	if err != nil {
+		return nil, err
+	}
+	return rankText(recipes, query, limit), nil
+}
+
+// likeStem shortens a stemmed term so that a LIKE pattern also finds its
+// inflections, e.g. "berr" for "berry" and "berries".
+func likeStem(term string) string {
+	if short := strings.TrimRight(term, "ey"); len(short) > 2 {
+		return short
+	}
+	return term
+}
+
+func (s *SQLStore) Create(ctx context.Context, recipe *models.Recipe) error {
+	recipe.ID = primitive.NewObjectID()
+	recipe.PublishedAt = time.Now()
+	recipe.UpdatedAt = recipe.PublishedAt
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		return s.insert(ctx, tx, *recipe)
+	})
+}
+
+func (s *SQLStore) Update(ctx context.Context, id string, recipe *models.Recipe) error {
+	rID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	recipe.ID = rID
+	recipe.UpdatedAt = time.Now()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.rebind(`UPDATE recipes SET name = ?, servings = ?, updated_by = ?, updated_at = ? WHERE id = ?`),
+			recipe.Name, recipe.Servings, recipe.UpdatedBy, recipe.UpdatedAt.UTC(), id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		if err := s.deleteChildren(ctx, tx, id); err != nil {
+			return err
+		}
+		return s.insertChildren(ctx, tx, *recipe)
+	})
+}
+
+func (s *SQLStore) Delete(ctx context.Context, id string) error {
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if err := s.deleteChildren(ctx, tx, id); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM recipes WHERE id = ?`), id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *SQLStore) GetUser(ctx context.Context, username string) (models.User, error) {
+	return s.getUser(ctx, "username", username)
+}
+
+func (s *SQLStore) GetUserBySubject(ctx context.Context, subject string) (models.User, error) {
+	if subject == "" {
+		return models.User{}, ErrUserNotFound
+	}
+	return s.getUser(ctx, "subject", subject)
+}
+
+func (s *SQLStore) getUser(ctx context.Context, column string, value string) (models.User, error) {
+	var user models.User
+	var createdAt sql.NullTime
+	var recoveryCodes string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT username, password, email, role, created_at, subject,
+		totp_secret, totp_enabled, totp_last_counter, totp_recovery_codes FROM users WHERE `+column+` = ?`), value).
+		Scan(&user.Username, &user.Password, &user.Email, &user.Role, &createdAt, &user.Subject,
+			&user.TOTP.Secret, &user.TOTP.Enabled, &user.TOTP.LastCounter, &recoveryCodes)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	}
+	user.CreatedAt = createdAt.Time
+	user.TOTP.RecoveryCodes = strings.Fields(recoveryCodes)
+	return user, err
+}
+
+func (s *SQLStore) CreateUser(ctx context.Context, user *models.User) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO users (username, password, email, role, created_at, subject) VALUES (?, ?, ?, ?, ?, ?)`),
+		user.Username, user.Password, user.Email, user.Role, user.CreatedAt.UTC(), user.Subject)
+	if isUniqueViolation(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a violation of a primary key or
+// unique index, such as an existing username or OIDC subject.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
+
+func (s *SQLStore) UpdatePassword(ctx context.Context, username, password string) error {
+	return s.updateUser(ctx, username, "password", password)
+}
+
+func (s *SQLStore) UpdateRole(ctx context.Context, username string, role models.Role) error {
+	return s.updateUser(ctx, username, "role", role)
+}
+
+func (s *SQLStore) UpdateTOTP(ctx context.Context, username string, totp models.TOTP) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET totp_secret = ?, totp_enabled = ?, totp_last_counter = ?, totp_recovery_codes = ? WHERE username = ?`),
+		totp.Secret, totp.Enabled, totp.LastCounter, strings.Join(totp.RecoveryCodes, " "), username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) SwapTOTP(ctx context.Context, username string, previous, totp models.TOTP) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET totp_secret = ?, totp_enabled = ?, totp_last_counter = ?, totp_recovery_codes = ?
+		WHERE username = ? AND totp_last_counter = ? AND totp_recovery_codes = ?`),
+		totp.Secret, totp.Enabled, totp.LastCounter, strings.Join(totp.RecoveryCodes, " "),
+		username, previous.LastCounter, strings.Join(previous.RecoveryCodes, " "))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTOTPChanged
+	}
+	return nil
+}
+
+func (s *SQLStore) updateUser(ctx context.Context, username, column string, value interface{}) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE users SET `+column+` = ? WHERE username = ?`), value, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO api_keys (id, username, name, hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		key.ID, key.Username, key.Name, key.Hash, joinScopes(key.Scopes), key.CreatedAt.UTC(), key.ExpiresAt.UTC())
+	return err
+}
+
+func (s *SQLStore) ListAPIKeys(ctx context.Context, username string) ([]models.APIKey, error) {
+	return s.findAPIKeys(ctx, `username = ? ORDER BY created_at, id`, username)
+}
+
+func (s *SQLStore) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	keys, err := s.findAPIKeys(ctx, `hash = ?`, hash)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if len(keys) == 0 {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	return keys[0], nil
+}
+
+func (s *SQLStore) DeleteAPIKey(ctx context.Context, username, id string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM api_keys WHERE id = ? AND username = ?`), id, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) TouchAPIKey(ctx context.Context, id string, t time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`), t.UTC(), id)
+	return err
+}
+
+func (s *SQLStore) findAPIKeys(ctx context.Context, where string, args ...interface{}) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, username, name, hash, scopes, created_at, expires_at, last_used_at FROM api_keys WHERE `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		var key models.APIKey
+		var scopes string
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Username, &key.Name, &key.Hash, &scopes, &key.CreatedAt, &key.ExpiresAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		key.Scopes = splitScopes(scopes)
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// joinScopes and splitScopes store the scopes of an API key as a
+// space-separated list, in the fashion of OAuth scopes.
+func joinScopes(scopes []models.Permission) string {
+	parts := make([]string, len(scopes))
+	for i, scope := range scopes {
+		parts[i] = string(scope)
+	}
+	return strings.Join(parts, " ")
+}
+
+func splitScopes(value string) []models.Permission {
+	scopes := make([]models.Permission, 0)
+	for _, part := range strings.Fields(value) {
+		scopes = append(scopes, models.Permission(part))
+	}
+	return scopes
+}
+
+// find loads all recipes matching the where clause, including their tags,
+// ingredients and instructions. An empty where clause matches all recipes.
+func (s *SQLStore) find(ctx context.Context, where string, args []interface{}) ([]models.Recipe, error) {
+	if where == "" {
+		where = "1 = 1"
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, name, servings, published_at, created_by, updated_by, updated_at FROM recipes WHERE `+where+` ORDER BY published_at, id`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recipes := make([]models.Recipe, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var id string
+		var recipe models.Recipe
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&id, &recipe.Name, &recipe.Servings, &recipe.PublishedAt, &recipe.CreatedBy, &recipe.UpdatedBy, &updatedAt); err != nil {
+			return nil, err
+		}
+		recipe.UpdatedAt = updatedAt.Time
+		if recipe.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		recipe.Tags = make([]string, 0)
+		recipe.Ingredients = make([]ingredient.Ingredient, 0)
+		recipe.Instructions = make([]string, 0)
+		index[id] = len(recipes)
+		recipes = append(recipes, recipe)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	children := []struct {
+		table, column string
+		field         func(*models.Recipe) *[]string
+	}{
+		{"recipe_tags", "tag", func(r *models.Recipe) *[]string { return &r.Tags }},
+		{"recipe_instructions", "instruction", func(r *models.Recipe) *[]string { return &r.Instructions }},
+	}
+	for _, child := range children {
+		query := fmt.Sprintf(`SELECT recipe_id, %s FROM %s WHERE recipe_id IN (SELECT id FROM recipes WHERE %s) ORDER BY recipe_id, position`,
+			child.column, child.table, where)
+		if err := s.loadChild(ctx, query, args, recipes, index, child.field); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.loadIngredients(ctx, where, args, recipes, index); err != nil {
+		return nil, err
+	}
+	return recipes, nil
+}
+
+func (s *SQLStore) loadChild(ctx context.Context, query string, args []interface{}, recipes []models.Recipe, index map[string]int, field func(*models.Recipe) *[]string) error {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		if i, ok := index[id]; ok {
+			values := field(&recipes[i])
+			*values = append(*values, value)
+		}
+	}
+	return rows.Err()
+}
+
+// loadIngredients adds the ingredients of the recipes matching the where
+// clause. Rows not parsed yet are parsed from their original line.
+func (s *SQLStore) loadIngredients(ctx context.Context, where string, args []interface{}, recipes []models.Recipe, index map[string]int) error {
+	query := `SELECT recipe_id, ingredient, quantity, quantity_max, unit, item, note FROM recipe_ingredients
+		WHERE recipe_id IN (SELECT id FROM recipes WHERE ` + where + `) ORDER BY recipe_id, position`
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, original, quantity, quantityMax, unit, note string
+		var item sql.NullString
+		if err := rows.Scan(&id, &original, &quantity, &quantityMax, &unit, &item, &note); err != nil {
+			return err
+		}
+		i, ok := index[id]
+		if !ok {
+			continue
+		}
+		ing := ingredient.Parse(original)
+		if item.Valid {
+			ing = ingredient.Ingredient{Unit: unit, Item: item.String, Note: note, Original: original}
+			if ing.Quantity, err = parseQuantity(quantity); err != nil {
+				return err
+			}
+			if ing.QuantityMax, err = parseQuantity(quantityMax); err != nil {
+				return err
+			}
+		}
+		recipes[i].Ingredients = append(recipes[i].Ingredients, ing)
+	}
+	return rows.Err()
+}
+
+// BackfillIngredients parses the ingredient lines stored before ingredients
+// were parsed and returns the number of parsed lines.
+func (s *SQLStore) BackfillIngredients(ctx context.Context) (int, error) {
+	type line struct {
+		recipeID   string
+		position   int
+		ingredient string
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT recipe_id, position, ingredient FROM recipe_ingredients WHERE item IS NULL`)
+	if err != nil {
+		return 0, errors.Wrap(err, "While selecting unparsed ingredients")
+	}
+	lines := make([]line, 0)
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.recipeID, &l.position, &l.ingredient); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		query := s.rebind(`UPDATE recipe_ingredients SET ingredient = ?, quantity = ?, quantity_max = ?, unit = ?, item = ?, note = ? WHERE recipe_id = ? AND position = ?`)
+		for _, l := range lines {
+			ing := ingredient.Parse(l.ingredient)
+			if _, err := tx.ExecContext(ctx, query, ing.Original, formatQuantity(ing.Quantity), formatQuantity(ing.QuantityMax),
+				ing.Unit, ing.Item, ing.Note, l.recipeID, l.position); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "While parsing ingredients")
+	}
+	return len(lines), nil
+}
+
+func parseQuantity(value string) (*ingredient.Rational, error) {
+	if value == "" {
+		return nil, nil
+	}
+	quantity, err := ingredient.ParseRational(value)
+	if err != nil {
+		return nil, err
+	}
+	return &quantity, nil
+}
+
+func formatQuantity(quantity *ingredient.Rational) string {
+	if quantity == nil {
+		return ""
+	}
+	return quantity.String()
+}
+
+func (s *SQLStore) insert(ctx context.Context, tx *sql.Tx, recipe models.Recipe) error {
+	_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO recipes (id, name, servings, published_at, created_by, updated_by, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		recipe.ID.Hex(), recipe.Name, recipe.Servings, recipe.PublishedAt.UTC(), recipe.CreatedBy, recipe.UpdatedBy, recipe.UpdatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return s.insertChildren(ctx, tx, recipe)
+}
+
+func (s *SQLStore) insertChildren(ctx context.Context, tx *sql.Tx, recipe models.Recipe) error {
+	children := []struct {
+		table, column string
+		values        []string
+	}{
+		{"recipe_tags", "tag", recipe.Tags},
+		{"recipe_instructions", "instruction", recipe.Instructions},
+	}
+	for _, child := range children {
+		query := s.rebind(fmt.Sprintf(`INSERT INTO %s (recipe_id, position, %s) VALUES (?, ?, ?)`, child.table, child.column))
+		for i, value := range child.values {
+			if _, err := tx.ExecContext(ctx, query, recipe.ID.Hex(), i, value); err != nil {
+				return err
+			}
+		}
+	}
+	query := s.rebind(`INSERT INTO recipe_ingredients (recipe_id, position, ingredient, quantity, quantity_max, unit, item, note) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	for i, ing := range recipe.Ingredients {
+		if _, err := tx.ExecContext(ctx, query, recipe.ID.Hex(), i, ing.Text(),
+			formatQuantity(ing.Quantity), formatQuantity(ing.QuantityMax), ing.Unit, ing.Item, ing.Note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) deleteChildren(ctx context.Context, tx *sql.Tx, id string) error {
+	for _, table := range []string{"recipe_tags", "recipe_ingredients", "recipe_instructions"} {
+		if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM `+table+` WHERE recipe_id = ?`), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction, which is committed if fn returns nil
+// and rolled back otherwise.
+func (s *SQLStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebind replaces the `?` placeholders of query with `$n` for PostgreSQL.
+func (s *SQLStore) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// placeholders returns n comma separated `?` placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
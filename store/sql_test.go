@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"local/gin/gin-recipes-api/models"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// openTestDB opens an empty SQLite database in a temporary directory.
+func openTestDB(t *testing.T) *SQLStore {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "recipes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s, err := NewSQLStore(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newTestSQLStore returns a migrated SQLStore on an empty SQLite database.
+func newTestSQLStore(t *testing.T) *SQLStore {
+	t.Helper()
+	s := openTestDB(t)
+	if _, err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// zonedRecipes returns recipes published an hour apart, in chronological
+// order, with each time given in a different zone.
+func zonedRecipes() []models.Recipe {
+	base := time.Date(2021, 1, 17, 12, 0, 0, 0, time.UTC)
+	zones := []*time.Location{
+		time.FixedZone("CET", 3600),
+		time.FixedZone("EST", -5*3600),
+		time.UTC,
+		time.FixedZone("JST", 9*3600),
+		time.FixedZone("HST", -10*3600),
+	}
+	recipes := make([]models.Recipe, len(zones))
+	for i, zone := range zones {
+		recipes[i] = models.Recipe{
+			ID:          primitive.NewObjectID(),
+			Name:        string(rune('a' + i)),
+			PublishedAt: base.Add(time.Duration(i) * time.Hour).In(zone),
+		}
+	}
+	return recipes
+}
+
+// walk lists all recipes page by page and returns their names.
+func walk(t *testing.T, s RecipeStore, query PageQuery) []string {
+	t.Helper()
+	var names []string
+	for {
+		page, err := s.ListPage(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, recipe := range page.Recipes {
+			names = append(names, recipe.Name)
+		}
+		if page.Next == nil {
+			return names
+		}
+		if len(names) > 100 {
+			t.Fatalf("pagination does not terminate: %v", names)
+		}
+		query.After = page.Next
+	}
+}
+
+func TestSQLListPageMixedOffsets(t *testing.T) {
+	s := newTestSQLStore(t)
+	if err := s.Insert(context.Background(), zonedRecipes()); err != nil {
+		t.Fatal(err)
+	}
+	for _, limit := range []int{1, 2, 0} {
+		got := walk(t, s, PageQuery{SortBy: SortPublishedAt, Limit: limit})
+		if want := "abcde"; strings.Join(got, "") != want {
+			t.Errorf("ascending pages of %d = %v, want %s", limit, got, want)
+		}
+		got = walk(t, s, PageQuery{SortBy: SortPublishedAt, Desc: true, Limit: limit})
+		if want := "edcba"; strings.Join(got, "") != want {
+			t.Errorf("descending pages of %d = %v, want %s", limit, got, want)
+		}
+	}
+}
+
+func TestSQLNormalizeTimes(t *testing.T) {
+	ctx := context.Background()
+	s := openTestDB(t)
+	all := migrations
+	defer func() { migrations = all }()
+	for version := range dataMigrations {
+		if version > len(all) {
+			t.Fatalf("data migration %d has no entry in migrations", version)
+		}
+	}
+	// stop before normalizeTimes
+	migrations = all[:30]
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// write the times with their offsets, as releases before the
+	// migration did
+	for _, recipe := range zonedRecipes() {
+		_, err := s.db.ExecContext(ctx, `INSERT INTO recipes (id, name, published_at) VALUES (?, ?, ?)`,
+			recipe.ID.Hex(), recipe.Name, recipe.PublishedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	migrations = all
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := walk(t, s, PageQuery{SortBy: SortPublishedAt, Limit: 2}); strings.Join(got, "") != "abcde" {
+		t.Errorf("pages after migration = %v, want abcde", got)
+	}
+	recipes, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, recipe := range recipes {
+		if want := zonedRecipes()[i].PublishedAt; !recipe.PublishedAt.Equal(want) {
+			t.Errorf("recipe %s published at %v, want %v", recipe.Name, recipe.PublishedAt, want)
+		}
+	}
+}
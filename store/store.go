@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"local/gin/gin-recipes-api/models"
+	"local/gin/gin-recipes-api/tagquery"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotFound is returned when no recipe matches the given ID.
+	ErrNotFound = errors.New("recipe not found")
+	// ErrInvalidID is returned when the given ID is not a valid recipe ID.
+	ErrInvalidID = errors.New("ID is not valid")
+)
+
+// RecipeStore abstracts the persistence of recipes so that the handlers
+// do not depend on a particular database.
+type RecipeStore interface {
+	// List returns all recipes.
+	List(ctx context.Context) ([]models.Recipe, error)
+	// ListPage returns the recipes selected by query.
+	ListPage(ctx context.Context, query PageQuery) (Page, error)
+	// Get returns the recipe with the given ID.
+	Get(ctx context.Context, id string) (models.Recipe, error)
+	// ListByAuthor returns all recipes created by the given user.
+	ListByAuthor(ctx context.Context, username string) ([]models.Recipe, error)
+	// Search returns all recipes whose tags satisfy the query.
+	Search(ctx context.Context, query tagquery.Expr) ([]models.Recipe, error)
+	// SearchText returns up to limit recipes matching the full-text query
+	// in their name, ingredients or instructions, best match first.
+	SearchText(ctx context.Context, query TextQuery, limit int) ([]TextMatch, error)
+	// Create stores a new recipe and sets its ID, PublishedAt and UpdatedAt.
+	Create(ctx context.Context, recipe *models.Recipe) error
+	// Update replaces name, tags, ingredients, instructions and UpdatedBy
+	// of the recipe with the given ID and sets its UpdatedAt.
+	Update(ctx context.Context, id string, recipe *models.Recipe) error
+	// Delete removes the recipe with the given ID.
+	Delete(ctx context.Context, id string) error
+}
+
+// Fields recipe listings can be sorted by. Ties are broken by ID.
+const (
+	SortPublishedAt = "publishedAt"
+	SortName        = "name"
+)
+
+// PageQuery selects one page of a sorted recipe listing.
+type PageQuery struct {
+	// SortBy is SortPublishedAt or SortName.
+	SortBy string
+	// Desc reverses the order.
+	Desc bool
+	// Limit is the maximum number of recipes in the page. Zero or less
+	// selects all remaining recipes.
+	Limit int
+	// After continues the listing behind the given position. A nil cursor
+	// starts with the first recipe.
+	After *Cursor
+}
+
+// Page is a page of recipes together with the position of the next one.
+type Page struct {
+	Recipes []models.Recipe
+	// Total is the number of recipes in all pages.
+	Total int64
+	// Next is nil on the last page.
+	Next *Cursor
+}
+
+// Cursor is the position of a recipe in a sorted listing. Keyset pagination
+// keeps pages stable while recipes are added or removed.
+type Cursor struct {
+	Name        string             `json:"name,omitempty"`
+	PublishedAt time.Time          `json:"publishedAt,omitempty"`
+	ID          primitive.ObjectID `json:"id"`
+}
+
+// CursorAt returns the position of recipe in a listing sorted by sortBy.
+func CursorAt(recipe models.Recipe, sortBy string) *Cursor {
+	cursor := &Cursor{ID: recipe.ID}
+	switch sortBy {
+	case SortName:
+		cursor.Name = recipe.Name
+	default:
+		cursor.PublishedAt = recipe.PublishedAt
+	}
+	return cursor
+}
+
+// ValidSort reports whether recipe listings can be sorted by field.
+func ValidSort(field string) bool {
+	return field == SortPublishedAt || field == SortName
+}
+
+// compare orders recipe relative to the cursor position in ascending
+// order: negative if the recipe comes first, positive if it comes later.
+func (c *Cursor) compare(recipe models.Recipe, sortBy string) int {
+	switch {
+	case sortBy == SortName && recipe.Name != c.Name:
+		return strings.Compare(recipe.Name, c.Name)
+	case sortBy != SortName && !recipe.PublishedAt.Equal(c.PublishedAt):
+		if recipe.PublishedAt.Before(c.PublishedAt) {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(recipe.ID[:], c.ID[:])
+}
+
+// value returns the sort key at the cursor position. Times are in UTC,
+// like the stored ones.
+func (c *Cursor) value(sortBy string) interface{} {
+	if sortBy == SortName {
+		return c.Name
+	}
+	return c.PublishedAt.UTC()
+}
+
+// newPage turns the result of a query for up to query.Limit+1 recipes into
+// a page. The extra recipe only tells that there is a next page.
+func newPage(recipes []models.Recipe, total int64, query PageQuery) Page {
+	page := Page{
+		Recipes: recipes,
+		Total:   total,
+	}
+	if query.Limit > 0 && len(recipes) > query.Limit {
+		page.Recipes = recipes[:query.Limit]
+		page.Next = CursorAt(page.Recipes[query.Limit-1], query.SortBy)
+	}
+	return page
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"local/gin/gin-recipes-api/ingredient"
+	"local/gin/gin-recipes-api/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Weights of the recipe fields in full-text searches. A term found in the
+// name counts ten times as much as one found in the instructions.
+const (
+	nameWeight         = 10
+	ingredientsWeight  = 5
+	instructionsWeight = 1
+)
+
+// TextMatch is a recipe found by a full-text search together with its
+// relevance. Higher scores are better matches.
+type TextMatch struct {
+	models.Recipe `bson:",inline"`
+	Score         float64 `json:"score" bson:"score"`
+}
+
+// TextQuery is a full-text search in the syntax of MongoDB's $text
+// operator: words, "quoted phrases" and words or phrases negated by a
+// leading minus. A recipe matches if it contains any of the words, or all
+// of the phrases if there are any, and none of the negated terms.
+type TextQuery struct {
+	// Raw is the query as given by the client.
+	Raw string
+	// Terms are the stemmed words of the query, including those of phrases.
+	Terms []string
+	// Phrases are in lower case with single spaces between words.
+	Phrases         []string
+	ExcludedTerms   []string
+	ExcludedPhrases []string
+}
+
+// ParseTextQuery parses a full-text search. Stop words are dropped.
+func ParseTextQuery(raw string) TextQuery {
+	query := TextQuery{Raw: raw}
+	rest := strings.TrimSpace(raw)
+	for rest != "" {
+		negated := strings.HasPrefix(rest, "-")
+		if negated {
+			rest = rest[1:]
+		}
+		var token string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			token, rest = rest[1:end+1], strings.TrimPrefix(rest[end+1:], `"`)
+			phrase := strings.Join(strings.Fields(strings.ToLower(token)), " ")
+			if phrase != "" && negated {
+				query.ExcludedPhrases = append(query.ExcludedPhrases, phrase)
+			} else if phrase != "" {
+				query.Phrases = append(query.Phrases, phrase)
+				query.Terms = append(query.Terms, terms(phrase)...)
+			}
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			token, rest = rest[:end], rest[end:]
+			if negated {
+				query.ExcludedTerms = append(query.ExcludedTerms, terms(token)...)
+			} else {
+				query.Terms = append(query.Terms, terms(token)...)
+			}
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	return query
+}
+
+// Empty reports whether the query has nothing a recipe could match.
+func (q TextQuery) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// rankText scores the recipes against query and returns the matching ones,
+// best first, but at most limit of them.
+func rankText(recipes []models.Recipe, query TextQuery, limit int) []TextMatch {
+	matches := make([]TextMatch, 0)
+	for _, recipe := range recipes {
+		if score := scoreText(recipe, query); score > 0 {
+			matches = append(matches, TextMatch{Recipe: recipe, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// scoreText returns the relevance of recipe for query, or 0 if the recipe
+// does not match. Every term found in a field adds between half and all of
+// the field's weight, depending on how much of the field the term makes up.
+func scoreText(recipe models.Recipe, query TextQuery) float64 {
+	fields := []struct {
+		weight float64
+		text   string
+	}{
+		{nameWeight, recipe.Name},
+		{ingredientsWeight, ingredientText(recipe)},
+		{instructionsWeight, strings.Join(recipe.Instructions, "\n")},
+	}
+	phrases := make(map[string]bool)
+	score := 0.0
+	for _, field := range fields {
+		text := strings.Join(strings.Fields(strings.ToLower(field.text)), " ")
+		for _, phrase := range query.ExcludedPhrases {
+			if strings.Contains(text, phrase) {
+				return 0
+			}
+		}
+		for _, phrase := range query.Phrases {
+			if strings.Contains(text, phrase) {
+				phrases[phrase] = true
+			}
+		}
+		words := terms(text)
+		counts := make(map[string]int, len(words))
+		for _, word := range words {
+			counts[word]++
+		}
+		for _, term := range query.ExcludedTerms {
+			if counts[term] > 0 {
+				return 0
+			}
+		}
+		for _, term := range query.Terms {
+			if n := counts[term]; n > 0 {
+				score += field.weight * (0.5 + 0.5*float64(n)/float64(len(words)))
+			}
+		}
+	}
+	if len(phrases) < len(query.Phrases) {
+		return 0
+	}
+	return score
+}
+
+func ingredientText(recipe models.Recipe) string {
+	lines := make([]string, 0, len(recipe.Ingredients))
+	for _, ing := range recipe.Ingredients {
+		lines = append(lines, ing.Text())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// terms splits text into stemmed, lower case words without stop words.
+func terms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	out := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if word == "" || stopWords[word] {
+			continue
+		}
+		out = append(out, ingredient.Singular(word))
+	}
+	return out
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "into": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "the": true,
+	"then": true, "to": true, "until": true, "with": true,
+}
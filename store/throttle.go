@@ -0,0 +1,137 @@
+package store
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// SignInThrottle counts failed sign-ins per username and per client IP in
+// Redis and blocks further attempts once they pile up.
+//
+// Each failure for a username blocks that username for a backoff that
+// doubles with every failure, until userThreshold failures lock it for
+// lockout. Per IP only the lockout applies, after ipThreshold failures, which
+// catches guessing across many usernames. Counters are forgotten window after
+// the last failure. The keys are
+//
+//	signin_fail_user:<username>    failures of the username
+//	signin_fail_ip:<ip>            failures from the IP
+//	signin_block_user:<username>   present while the username is blocked
+//	signin_block_ip:<ip>           present while the IP is blocked
+type SignInThrottle struct {
+	redisClient   *redis.Client
+	userThreshold int64
+	ipThreshold   int64
+	window        time.Duration
+	backoff       time.Duration
+	lockout       time.Duration
+}
+
+func NewSignInThrottle(redisClient *redis.Client, userThreshold, ipThreshold int, window, backoff, lockout time.Duration) *SignInThrottle {
+	return &SignInThrottle{
+		redisClient:   redisClient,
+		userThreshold: int64(userThreshold),
+		ipThreshold:   int64(ipThreshold),
+		window:        window,
+		backoff:       backoff,
+		lockout:       lockout,
+	}
+}
+
+// Check returns how long sign-ins for username from ip are still blocked,
+// or zero if they are allowed.
+func (t *SignInThrottle) Check(username, ip string) (time.Duration, error) {
+	var user, addr *redis.DurationCmd
+	_, err := t.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		user = pipe.PTTL(blockUserKey(username))
+		addr = pipe.PTTL(blockIPKey(ip))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// PTTL reports negative durations for missing keys
+	return maxDuration(user.Val(), addr.Val(), 0), nil
+}
+
+// Fail records a failed sign-in for username from ip and returns how long
+// further sign-ins are blocked.
+func (t *SignInThrottle) Fail(username, ip string) (time.Duration, error) {
+	var user, addr *redis.IntCmd
+	_, err := t.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		user = pipe.Incr(failUserKey(username))
+		pipe.Expire(failUserKey(username), t.window)
+		addr = pipe.Incr(failIPKey(ip))
+		pipe.Expire(failIPKey(ip), t.window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	userDelay := t.backoffAfter(user.Val())
+	var ipDelay time.Duration
+	if addr.Val() >= t.ipThreshold {
+		ipDelay = t.lockout
+	}
+	_, err = t.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		if userDelay > 0 {
+			pipe.Set(blockUserKey(username), user.Val(), userDelay)
+		}
+		if ipDelay > 0 {
+			pipe.Set(blockIPKey(ip), addr.Val(), ipDelay)
+		}
+		return nil
+	})
+	return maxDuration(userDelay, ipDelay, 0), err
+}
+
+// Reset forgets the failed sign-ins of username after a successful sign-in.
+// The counter of the IP is kept, so that an attacker cannot reset it by
+// signing in to an account of their own.
+func (t *SignInThrottle) Reset(username string) error {
+	return t.redisClient.Del(failUserKey(username), blockUserKey(username)).Err()
+}
+
+// backoffAfter returns the block after the nth failure of a username:
+// backoff, 2*backoff, 4*backoff, ... capped at lockout, and lockout from
+// the userThreshold-th failure on.
+func (t *SignInThrottle) backoffAfter(n int64) time.Duration {
+	if n >= t.userThreshold {
+		return t.lockout
+	}
+	delay := t.backoff
+	for i := int64(1); i < n && delay < t.lockout; i++ {
+		delay *= 2
+	}
+	if delay > t.lockout {
+		delay = t.lockout
+	}
+	return delay
+}
+
+func maxDuration(durations ...time.Duration) time.Duration {
+	max := durations[0]
+	for _, d := range durations[1:] {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func failUserKey(username string) string {
+	return "signin_fail_user:" + username
+}
+
+func failIPKey(ip string) string {
+	return "signin_fail_ip:" + ip
+}
+
+func blockUserKey(username string) string {
+	return "signin_block_user:" + username
+}
+
+func blockIPKey(ip string) string {
+	return "signin_block_ip:" + ip
+}
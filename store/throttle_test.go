@@ -0,0 +1,92 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignInThrottleBackoff(t *testing.T) {
+	client, mr := newTestRedis(t)
+	throttle := NewSignInThrottle(client, 5, 50, time.Hour, time.Second, 15*time.Minute)
+	// failures past the threshold lock the username again as long as the
+	// counter lives
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 15 * time.Minute, 15 * time.Minute} {
+		delay, err := throttle.Fail("alice", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delay != want {
+			t.Errorf("failure %d: Fail() = %v, want %v", i+1, delay, want)
+		}
+		if blocked, _ := throttle.Check("alice", "10.0.0.2"); blocked <= 0 || blocked > want {
+			t.Errorf("failure %d: Check() = %v, want the username blocked for %v", i+1, blocked, want)
+		}
+		mr.FastForward(delay)
+		if blocked, _ := throttle.Check("alice", "10.0.0.1"); blocked != 0 {
+			t.Errorf("failure %d: Check() after the block = %v, want 0", i+1, blocked)
+		}
+	}
+	if blocked, _ := throttle.Check("bob", "10.0.0.1"); blocked != 0 {
+		t.Errorf("Check(other user) = %v, want 0", blocked)
+	}
+}
+
+func TestSignInThrottleBackoffCap(t *testing.T) {
+	client, _ := newTestRedis(t)
+	throttle := NewSignInThrottle(client, 10, 50, time.Hour, time.Second, 5*time.Second)
+	var delay time.Duration
+	for i := 0; i < 5; i++ {
+		var err error
+		if delay, err = throttle.Fail("alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if delay != 5*time.Second {
+		t.Errorf("Fail() = %v, want the backoff capped at the lockout", delay)
+	}
+}
+
+func TestSignInThrottleIP(t *testing.T) {
+	client, mr := newTestRedis(t)
+	throttle := NewSignInThrottle(client, 5, 3, 15*time.Minute, time.Second, 15*time.Minute)
+	for _, username := range []string{"alice", "bob"} {
+		if delay, _ := throttle.Fail(username, "10.0.0.1"); delay != time.Second {
+			t.Errorf("Fail(%s) = %v, want only the username blocked", username, delay)
+		}
+	}
+	if delay, _ := throttle.Fail("carol", "10.0.0.1"); delay != 15*time.Minute {
+		t.Errorf("Fail() at the IP threshold = %v, want the lockout", delay)
+	}
+	mr.FastForward(time.Second)
+	if blocked, _ := throttle.Check("dave", "10.0.0.1"); blocked <= 0 {
+		t.Errorf("Check(other user, same IP) = %v, want the IP blocked", blocked)
+	}
+	if blocked, _ := throttle.Check("dave", "10.0.0.2"); blocked != 0 {
+		t.Errorf("Check(other IP) = %v, want 0", blocked)
+	}
+}
+
+func TestSignInThrottleReset(t *testing.T) {
+	client, mr := newTestRedis(t)
+	throttle := NewSignInThrottle(client, 5, 3, 15*time.Minute, time.Second, 15*time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := throttle.Fail("alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := throttle.Reset("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := throttle.Check("alice", "10.0.0.1"); blocked != 0 {
+		t.Errorf("Check() after Reset() = %v, want 0", blocked)
+	}
+	if delay, _ := throttle.Fail("alice", "10.0.0.1"); delay != 15*time.Minute {
+		t.Errorf("Fail() after Reset() = %v, want the IP counter kept and the IP locked", delay)
+	}
+
+	// counters are forgotten a window after the last failure
+	mr.FastForward(15 * time.Minute)
+	if delay, _ := throttle.Fail("alice", "10.0.0.1"); delay != time.Second {
+		t.Errorf("Fail() after the window = %v, want the first backoff", delay)
+	}
+}
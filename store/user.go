@@ -0,0 +1,257 @@
+package store
+
+import (
+	"context"
+	"local/gin/gin-recipes-api/models"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches the given username.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when creating a user whose username is taken.
+	ErrUserExists = errors.New("user already exists")
+	// ErrTOTPChanged is returned by SwapTOTP when the stored settings are no
+	// longer those the caller read, e.g. because a concurrent sign-in used
+	// the same code.
+	ErrTOTPChanged = errors.New("two-factor settings changed concurrently")
+)
+
+// UserStore abstracts the persistence of users.
+type UserStore interface {
+	// GetUser returns the user with the given username.
+	GetUser(ctx context.Context, username string) (models.User, error)
+	// GetUserBySubject returns the user linked to the given OpenID Connect subject.
+	GetUserBySubject(ctx context.Context, subject string) (models.User, error)
+	// CreateUser stores a new user.
+	CreateUser(ctx context.Context, user *models.User) error
+	// UpdatePassword replaces the password hash of the given user.
+	UpdatePassword(ctx context.Context, username, password string) error
+	// UpdateRole changes the role of the given user.
+	UpdateRole(ctx context.Context, username string, role models.Role) error
+	// UpdateTOTP replaces the two-factor authentication settings of the given user.
+	UpdateTOTP(ctx context.Context, username string, totp models.TOTP) error
+	// SwapTOTP replaces the two-factor authentication settings of the given
+	// user with totp if their last accepted code and recovery codes are still
+	// those of previous, and returns ErrTOTPChanged otherwise.
+	SwapTOTP(ctx context.Context, username string, previous, totp models.TOTP) error
+}
+
+// MongoUserStore is a UserStore backed by a MongoDB collection.
+type MongoUserStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoUserStore(collection *mongo.Collection) *MongoUserStore {
+	return &MongoUserStore{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the unique indexes on username and subject that
+// CreateUser relies on to reject duplicate users.
+func (s *MongoUserStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "subject", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"subject": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+// BackfillRoles makes users signed up before roles existed authors and
+// returns the number of updated users.
+func (s *MongoUserStore) BackfillRoles(ctx context.Context) (int64, error) {
+	result, err := s.collection.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"role": bson.M{"$exists": false}}, bson.M{"role": ""}}},
+		bson.M{"$set": bson.M{"role": models.RoleAuthor}})
+	if err != nil {
+		return 0, errors.Wrap(err, "While backfilling roles")
+	}
+	return result.ModifiedCount, nil
+}
+
+func (s *MongoUserStore) GetUser(ctx context.Context, username string) (models.User, error) {
+	return s.findOne(ctx, bson.M{"username": username})
+}
+
+func (s *MongoUserStore) GetUserBySubject(ctx context.Context, subject string) (models.User, error) {
+	return s.findOne(ctx, bson.M{"subject": subject})
+}
+
+func (s *MongoUserStore) findOne(ctx context.Context, filter bson.M) (models.User, error) {
+	var user models.User
+	err := s.collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *MongoUserStore) CreateUser(ctx context.Context, user *models.User) error {
+	_, err := s.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (s *MongoUserStore) UpdatePassword(ctx context.Context, username, password string) error {
+	return s.set(ctx, username, bson.M{"password": password})
+}
+
+func (s *MongoUserStore) UpdateRole(ctx context.Context, username string, role models.Role) error {
+	return s.set(ctx, username, bson.M{"role": role})
+}
+
+func (s *MongoUserStore) UpdateTOTP(ctx context.Context, username string, totp models.TOTP) error {
+	return s.set(ctx, username, bson.M{"totp": totp})
+}
+
+func (s *MongoUserStore) SwapTOTP(ctx context.Context, username string, previous, totp models.TOTP) error {
+	// Zero values are omitted from the stored document.
+	filter := bson.M{"username": username}
+	if previous.LastCounter == 0 {
+		filter["totp.lastCounter"] = bson.M{"$exists": false}
+	} else {
+		filter["totp.lastCounter"] = previous.LastCounter
+	}
+	if len(previous.RecoveryCodes) == 0 {
+		filter["totp.recoveryCodes"] = bson.M{"$exists": false}
+	} else {
+		filter["totp.recoveryCodes"] = previous.RecoveryCodes
+	}
+	res, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp": totp}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTOTPChanged
+	}
+	return nil
+}
+
+func (s *MongoUserStore) set(ctx context.Context, username string, fields bson.M) error {
+	res, err := s.collection.UpdateOne(ctx, bson.M{"username": username}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MemoryUserStore is a thread-safe UserStore keeping all users in memory.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]models.User
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users: make(map[string]models.User),
+	}
+}
+
+func (s *MemoryUserStore) GetUser(ctx context.Context, username string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[username]
+	if !ok {
+		return user, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) GetUserBySubject(ctx context.Context, subject string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		if subject != "" && user.Subject == subject {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+func (s *MemoryUserStore) CreateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Username]; ok {
+		return ErrUserExists
+	}
+	for _, other := range s.users {
+		if user.Subject != "" && other.Subject == user.Subject {
+			return ErrUserExists
+		}
+	}
+	s.users[user.Username] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) UpdatePassword(ctx context.Context, username, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Password = password
+	s.users[username] = user
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateRole(ctx context.Context, username string, role models.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Role = role
+	s.users[username] = user
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateTOTP(ctx context.Context, username string, totp models.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	totp.RecoveryCodes = append([]string(nil), totp.RecoveryCodes...)
+	user.TOTP = totp
+	s.users[username] = user
+	return nil
+}
+
+func (s *MemoryUserStore) SwapTOTP(ctx context.Context, username string, previous, totp models.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.TOTP.LastCounter != previous.LastCounter ||
+		strings.Join(user.TOTP.RecoveryCodes, " ") != strings.Join(previous.RecoveryCodes, " ") {
+		return ErrTOTPChanged
+	}
+	totp.RecoveryCodes = append([]string(nil), totp.RecoveryCodes...)
+	user.TOTP = totp
+	s.users[username] = user
+	return nil
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"local/gin/gin-recipes-api/models"
+
+	"github.com/pkg/errors"
+)
+
+// forEachUserStore runs test against an empty UserStore and APIKeyStore of
+// every backend that works without a server.
+func forEachUserStore(t *testing.T, test func(t *testing.T, users UserStore, keys APIKeyStore)) {
+	t.Run("memory", func(t *testing.T) { test(t, NewMemoryUserStore(), NewMemoryAPIKeyStore()) })
+	t.Run("sql", func(t *testing.T) {
+		s := newTestSQLStore(t)
+		test(t, s, s)
+	})
+}
+
+func TestUserStore(t *testing.T) {
+	forEachUserStore(t, func(t *testing.T, users UserStore, _ APIKeyStore) {
+		ctx := context.Background()
+		created := time.Date(2021, 1, 17, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+		alice := models.User{Username: "alice", Password: "hash", Email: "alice@example.com", Role: models.RoleAuthor, CreatedAt: created}
+		if err := users.CreateUser(ctx, &alice); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.CreateUser(ctx, &models.User{Username: "alice", Password: "other"}); !errors.Is(err, ErrUserExists) {
+			t.Errorf("CreateUser(taken username) = %v, want ErrUserExists", err)
+		}
+		got, err := users.GetUser(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Password != "hash" || got.Email != alice.Email || got.Role != models.RoleAuthor || !got.CreatedAt.Equal(created) {
+			t.Errorf("GetUser() = %+v, want %+v", got, alice)
+		}
+		if _, err := users.GetUser(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetUser(unknown) = %v, want ErrUserNotFound", err)
+		}
+
+		if err := users.UpdatePassword(ctx, "alice", "new hash"); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.UpdateRole(ctx, "alice", models.RoleEditor); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := users.GetUser(ctx, "alice"); got.Password != "new hash" || got.Role != models.RoleEditor {
+			t.Errorf("GetUser() after updates = %+v", got)
+		}
+		if err := users.UpdatePassword(ctx, "nobody", "hash"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("UpdatePassword(unknown) = %v, want ErrUserNotFound", err)
+		}
+		if err := users.UpdateRole(ctx, "nobody", models.RoleAdmin); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("UpdateRole(unknown) = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestUserStoreSubject(t *testing.T) {
+	forEachUserStore(t, func(t *testing.T, users UserStore, _ APIKeyStore) {
+		ctx := context.Background()
+		for _, user := range []models.User{
+			{Username: "alice", Role: models.RoleAuthor, Subject: "sub-1"},
+			{Username: "bob", Password: "hash", Role: models.RoleAuthor},
+			{Username: "carol", Password: "hash", Role: models.RoleAuthor},
+		} {
+			if err := users.CreateUser(ctx, &user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := users.CreateUser(ctx, &models.User{Username: "mallory", Subject: "sub-1"}); !errors.Is(err, ErrUserExists) {
+			t.Errorf("CreateUser(taken subject) = %v, want ErrUserExists", err)
+		}
+		if got, err := users.GetUserBySubject(ctx, "sub-1"); err != nil || got.Username != "alice" {
+			t.Errorf("GetUserBySubject(sub-1) = %q, %v, want alice", got.Username, err)
+		}
+		// password users have no subject and must not match an empty one
+		for _, subject := range []string{"", "sub-2"} {
+			if _, err := users.GetUserBySubject(ctx, subject); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("GetUserBySubject(%q) = %v, want ErrUserNotFound", subject, err)
+			}
+		}
+	})
+}
+
+func TestUserStoreTOTP(t *testing.T) {
+	forEachUserStore(t, func(t *testing.T, users UserStore, _ APIKeyStore) {
+		ctx := context.Background()
+		if err := users.CreateUser(ctx, &models.User{Username: "alice", Password: "hash", Role: models.RoleAuthor}); err != nil {
+			t.Fatal(err)
+		}
+		enabled := models.TOTP{Secret: "SECRET", Enabled: true, LastCounter: 10, RecoveryCodes: []string{"a", "b"}}
+		if err := users.UpdateTOTP(ctx, "alice", enabled); err != nil {
+			t.Fatal(err)
+		}
+		used := enabled
+		used.LastCounter = 11
+		if err := users.SwapTOTP(ctx, "alice", enabled, used); err != nil {
+			t.Fatal(err)
+		}
+		// a concurrent sign-in read the settings before the swap
+		if err := users.SwapTOTP(ctx, "alice", enabled, used); !errors.Is(err, ErrTOTPChanged) {
+			t.Errorf("SwapTOTP(stale counter) = %v, want ErrTOTPChanged", err)
+		}
+		recovered := used
+		recovered.RecoveryCodes = []string{"b"}
+		if err := users.SwapTOTP(ctx, "alice", used, recovered); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.SwapTOTP(ctx, "alice", used, recovered); !errors.Is(err, ErrTOTPChanged) {
+			t.Errorf("SwapTOTP(stale recovery codes) = %v, want ErrTOTPChanged", err)
+		}
+		got, err := users.GetUser(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TOTP.Secret != "SECRET" || !got.TOTP.Enabled || got.TOTP.LastCounter != 11 || strings.Join(got.TOTP.RecoveryCodes, " ") != "b" {
+			t.Errorf("TOTP = %+v, want %+v", got.TOTP, recovered)
+		}
+		if err := users.UpdateTOTP(ctx, "nobody", enabled); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("UpdateTOTP(unknown) = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestAPIKeyStore(t *testing.T) {
+	forEachUserStore(t, func(t *testing.T, _ UserStore, keys APIKeyStore) {
+		ctx := context.Background()
+		created := time.Date(2021, 1, 17, 12, 0, 0, 0, time.UTC)
+		for i, key := range []models.APIKey{
+			{ID: "k1", Username: "alice", Name: "ci", Hash: "h1", Scopes: []models.Permission{models.PermCreateRecipe, models.PermUpdateRecipe}},
+			{ID: "k2", Username: "alice", Name: "cron", Hash: "h2", Scopes: []models.Permission{models.PermDeleteRecipe}},
+			{ID: "k3", Username: "bob", Name: "ci", Hash: "h3", Scopes: []models.Permission{models.PermCreateRecipe}},
+		} {
+			key.CreatedAt = created.Add(time.Duration(i) * time.Minute)
+			key.ExpiresAt = key.CreatedAt.Add(time.Hour)
+			if err := keys.CreateAPIKey(ctx, &key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		list, err := keys.ListAPIKeys(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		if len(list) != 2 || list[0].ID != "k1" || list[1].ID != "k2" {
+			t.Fatalf("ListAPIKeys(alice) = %+v, want k1 and k2", list)
+		}
+		if !list[0].HasScope(models.PermUpdateRecipe) || list[0].HasScope(models.PermDeleteRecipe) ||
+			!list[0].ExpiresAt.Equal(created.Add(time.Hour)) || list[0].LastUsedAt != nil {
+			t.Errorf("ListAPIKeys(alice)[0] = %+v", list[0])
+		}
+
+		used := created.Add(30 * time.Minute)
+		if err := keys.TouchAPIKey(ctx, "k1", used); err != nil {
+			t.Fatal(err)
+		}
+		key, err := keys.GetAPIKeyByHash(ctx, "h1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID != "k1" || key.Username != "alice" || key.LastUsedAt == nil || !key.LastUsedAt.Equal(used) {
+			t.Errorf("GetAPIKeyByHash(h1) = %+v, want k1 last used at %v", key, used)
+		}
+		if _, err := keys.GetAPIKeyByHash(ctx, "unknown"); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("GetAPIKeyByHash(unknown) = %v, want ErrAPIKeyNotFound", err)
+		}
+
+		if err := keys.DeleteAPIKey(ctx, "bob", "k1"); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("DeleteAPIKey(key of another user) = %v, want ErrAPIKeyNotFound", err)
+		}
+		if err := keys.DeleteAPIKey(ctx, "alice", "k1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.GetAPIKeyByHash(ctx, "h1"); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("GetAPIKeyByHash(deleted) = %v, want ErrAPIKeyNotFound", err)
+		}
+		if err := keys.DeleteAPIKey(ctx, "alice", "k1"); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("DeleteAPIKey(deleted) = %v, want ErrAPIKeyNotFound", err)
+		}
+	})
+}
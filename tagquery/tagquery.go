@@ -0,0 +1,332 @@
+// Package tagquery parses boolean queries over recipe tags such as
+//
+//	vegetarian AND (dinner OR lunch) AND NOT spicy
+//
+// into an abstract syntax tree that the stores translate to their own
+// query languages.
+//
+// The operators are NOT, AND and OR in decreasing order of precedence and
+// are case-insensitive. A semicolon is an alias for OR, so the former
+// "vegetarian;dinner" syntax keeps working. Tags containing spaces,
+// parentheses or semicolons, or spelled like an operator, are written in
+// double quotes.
+package tagquery
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxLength is the longest query Parse accepts.
+	MaxLength = 1024
+	// MaxDepth limits the nesting of parentheses and NOT operators.
+	MaxDepth = 32
+)
+
+// Expr is a node of a parsed query.
+type Expr interface {
+	// Match reports whether a recipe with the given tags satisfies the query.
+	Match(tags []string) bool
+	// String returns the query in canonical, fully parenthesized form.
+	String() string
+}
+
+// Tag matches recipes carrying the tag.
+type Tag struct {
+	Name string
+}
+
+// And matches recipes satisfying all operands.
+type And struct {
+	Operands []Expr
+}
+
+// Or matches recipes satisfying any operand.
+type Or struct {
+	Operands []Expr
+}
+
+// Not matches recipes not satisfying the operand.
+type Not struct {
+	Operand Expr
+}
+
+func (t Tag) Match(tags []string) bool {
+	for _, tag := range tags {
+		if tag == t.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a And) Match(tags []string) bool {
+	for _, operand := range a.Operands {
+		if !operand.Match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o Or) Match(tags []string) bool {
+	for _, operand := range o.Operands {
+		if operand.Match(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n Not) Match(tags []string) bool {
+	return !n.Operand.Match(tags)
+}
+
+func (t Tag) String() string {
+	if t.Name == "" || strings.IndexFunc(t.Name, isSpecial) >= 0 || keyword(t.Name) != "" {
+		return fmt.Sprintf("%q", t.Name)
+	}
+	return t.Name
+}
+
+func (a And) String() string {
+	return join(a.Operands, " AND ")
+}
+
+func (o Or) String() string {
+	return join(o.Operands, " OR ")
+}
+
+func (n Not) String() string {
+	return "NOT " + n.Operand.String()
+}
+
+func join(operands []Expr, sep string) string {
+	parts := make([]string, len(operands))
+	for i, operand := range operands {
+		parts[i] = operand.String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+// SyntaxError describes why a query could not be parsed.
+type SyntaxError struct {
+	// Offset is the byte offset in the query at which the error was detected.
+	Offset  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid tag query at position %d: %s", e.Offset+1, e.Message)
+}
+
+// Parse parses query. Errors are of type *SyntaxError.
+func Parse(query string) (Expr, error) {
+	if len(query) > MaxLength {
+		return nil, &SyntaxError{Offset: MaxLength, Message: fmt.Sprintf("query is longer than %d characters", MaxLength)}
+	}
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.unexpected(t, "AND or OR")
+	}
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenTag
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func lex(query string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, token{tokenOpen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenClose, ")", i})
+			i++
+		case r == ';':
+			tokens = append(tokens, token{tokenOr, ";", i})
+			i++
+		case r == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, &SyntaxError{Offset: i, Message: "missing closing quote"}
+			}
+			tokens = append(tokens, token{tokenTag, query[i+1 : i+1+end], i})
+			i += end + 2
+		default:
+			// r is not special, so the tag spans at least r itself.
+			end := strings.IndexFunc(query[i+size:], isSpecial)
+			if end < 0 {
+				end = len(query) - i - size
+			}
+			end += size
+			text := query[i : i+end]
+			kind := tokenTag
+			switch keyword(text) {
+			case "AND":
+				kind = tokenAnd
+			case "OR":
+				kind = tokenOr
+			case "NOT":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{kind, text, i})
+			i += end
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(query)}), nil
+}
+
+// isSpecial reports whether r ends an unquoted tag.
+func isSpecial(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == ';' || r == '"'
+}
+
+// keyword returns the operator spelled by text, if any.
+func keyword(text string) string {
+	switch upper := strings.ToUpper(text); upper {
+	case "AND", "OR", "NOT":
+		return upper
+	}
+	return ""
+}
+
+// parser is a recursive descent parser for the grammar
+//
+//	or      = and { ( "OR" | ";" ) and }
+//	and     = not { "AND" not }
+//	not     = "NOT" not | primary
+//	primary = tag | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) or(depth int) (Expr, error) {
+	first, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	operands := []Expr{first}
+	for p.peek().kind == tokenOr {
+		p.next()
+		operand, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return Or{Operands: operands}, nil
+}
+
+func (p *parser) and(depth int) (Expr, error) {
+	first, err := p.not(depth)
+	if err != nil {
+		return nil, err
+	}
+	operands := []Expr{first}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		operand, err := p.not(depth)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return And{Operands: operands}, nil
+}
+
+func (p *parser) not(depth int) (Expr, error) {
+	if depth > MaxDepth {
+		return nil, &SyntaxError{Offset: p.peek().offset, Message: fmt.Sprintf("query is nested deeper than %d levels", MaxDepth)}
+	}
+	if p.peek().kind == tokenNot {
+		p.next()
+		operand, err := p.not(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Operand: operand}, nil
+	}
+	return p.primary(depth)
+}
+
+func (p *parser) primary(depth int) (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenTag:
+		if t.text == "" {
+			return nil, &SyntaxError{Offset: t.offset, Message: "empty tag"}
+		}
+		return Tag{Name: t.text}, nil
+	case tokenOpen:
+		expr, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenClose {
+			if closing.kind == tokenEOF {
+				return nil, &SyntaxError{Offset: t.offset, Message: "missing closing parenthesis"}
+			}
+			return nil, p.unexpected(closing, "AND, OR or )")
+		}
+		return expr, nil
+	}
+	return nil, p.unexpected(t, "a tag, NOT or (")
+}
+
+func (p *parser) unexpected(t token, expected string) error {
+	if t.kind == tokenEOF {
+		return &SyntaxError{Offset: t.offset, Message: "unexpected end of query, expected " + expected}
+	}
+	return &SyntaxError{Offset: t.offset, Message: fmt.Sprintf("unexpected '%s', expected %s", t.text, expected)}
+}
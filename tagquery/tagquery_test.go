@@ -0,0 +1,119 @@
+package tagquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"vegetarian", "vegetarian"},
+		{"a AND b OR c", "((a AND b) OR c)"},
+		{"a OR b AND c", "(a OR (b AND c))"},
+		{"NOT a AND b", "(NOT a AND b)"},
+		{"NOT (a OR b)", "NOT (a OR b)"},
+		{"a and b or not c", "((a AND b) OR NOT c)"},
+		{"a;b;c", "(a OR b OR c)"},
+		{"vegetarian AND (dinner OR lunch) AND NOT spicy", "(vegetarian AND (dinner OR lunch) AND NOT spicy)"},
+		{`"main course" AND "and"`, `("main course" AND "and")`},
+		{`"a;b" OR "(c)"`, `("a;b" OR "(c)")`},
+		{"crème OR brûlée", "(crème OR brûlée)"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", test.query, err)
+			continue
+		}
+		if got := expr.String(); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{"", 0},
+		{"a AND", 5},
+		{"a b", 2},
+		{"(a OR b", 0},
+		{"a)", 1},
+		{`"a`, 0},
+		{`""`, 0},
+		{"NOT", 3},
+		{"a AND OR b", 6},
+		{strings.Repeat("(", MaxDepth+2) + "a" + strings.Repeat(")", MaxDepth+2), MaxDepth + 1},
+		{strings.Repeat("a", MaxLength+1), MaxLength},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.query)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) returned %v, want a *SyntaxError", test.query, err)
+			continue
+		}
+		if syntaxErr.Offset != test.offset {
+			t.Errorf("Parse(%q) failed at offset %d, want %d: %v", test.query, syntaxErr.Offset, test.offset, err)
+		}
+	}
+}
+
+func TestParseUnicode(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		err   bool
+	}{
+		{"\u00a0", "", true},
+		{"a\u00a0b", "", true},
+		{"a\u00a0OR\u2003b", "(a OR b)", false},
+		{"a AND \u2003b", "(a AND b)", false},
+		{"\u2003épicé\u00a0", "épicé", false},
+		{"日本 OR 中文", "(日本 OR 中文)", false},
+		{"\xff", "\xff", false},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.query)
+		if test.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want an error", test.query, expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", test.query, err)
+			continue
+		}
+		if got := expr.String(); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	expr, err := Parse("vegetarian AND (dinner OR lunch) AND NOT spicy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tags []string
+		want bool
+	}{
+		{[]string{"vegetarian", "dinner"}, true},
+		{[]string{"vegetarian", "lunch", "quick"}, true},
+		{[]string{"vegetarian", "dinner", "spicy"}, false},
+		{[]string{"vegetarian"}, false},
+		{[]string{"dinner"}, false},
+	}
+	for _, test := range tests {
+		if got := expr.Match(test.tags); got != test.want {
+			t.Errorf("Match(%v) = %v, want %v", test.tags, got, test.want)
+		}
+	}
+}